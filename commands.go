@@ -0,0 +1,154 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/eth0izzle/shhgit/core"
+	"github.com/fatih/color"
+	"gopkg.in/yaml.v3"
+)
+
+// runCommand runs a one-off command given as the first positional argument
+// instead of starting a scan, and returns the process exit code.
+func runCommand(options *core.Options, command string) int {
+	switch command {
+	case "test-signatures":
+		return testSignatures(options)
+	case "validate-config":
+		return validateConfig(options)
+	case "import-rules":
+		return importRules(flag.Args()[1:])
+	}
+
+	fmt.Printf("Unknown command %q. Available commands: test-signatures, validate-config, import-rules\n", command)
+	return 2
+}
+
+// testSignatures compiles every signature, runs its embedded examples and
+// reports failures and slow patterns.
+func testSignatures(options *core.Options) int {
+	config, err := core.LoadConfig(options)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	results := core.CheckSignatureExamples(config)
+	failed, slow, untested := 0, 0, 0
+
+	for _, result := range results {
+		switch {
+		case result.Error != nil:
+			failed++
+			color.Red("✗ %s: does not compile: %s", result.Name, result.Error)
+		case len(result.Failures) > 0:
+			failed++
+			color.Red("✗ %s", result.Name)
+			for _, failure := range result.Failures {
+				color.Red("    %s", failure)
+			}
+		case result.Examples == 0:
+			untested++
+			color.Yellow("- %s: no examples", result.Name)
+		default:
+			fmt.Printf("✓ %s (%d %s, %s)\n", result.Name, result.Examples, core.Pluralize(result.Examples, "example", "examples"), result.Duration.Round(time.Millisecond))
+		}
+
+		if result.Slow {
+			slow++
+			color.Yellow("! %s is slow: took %s to scan the benchmark corpus", result.Name, result.Duration)
+		}
+	}
+
+	fmt.Printf("\n%d signatures: %d failed, %d slow, %d without examples\n", len(results), failed, slow, untested)
+
+	if failed > 0 || slow > 0 {
+		return 1
+	}
+
+	return 0
+}
+
+// validateConfig reports every problem in the configuration and the files
+// it includes, with file and line numbers.
+func validateConfig(options *core.Options) int {
+	configPath, err := core.FindConfigPath(options)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	files := []string{configPath}
+	config, err := core.LoadConfig(options)
+	if err != nil {
+		color.Red("%s", err)
+	} else {
+		files = config.Files
+	}
+
+	problems := 0
+	for _, file := range files {
+		fileProblems, err := core.ValidateConfigFile(file)
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+
+		for _, problem := range fileProblems {
+			color.Red("%s", problem)
+		}
+		problems += len(fileProblems)
+	}
+
+	if err != nil || problems > 0 {
+		fmt.Printf("\n%d %s found in %d %s\n", problems, core.Pluralize(problems, "problem", "problems"), len(files), core.Pluralize(len(files), "file", "files"))
+		return 1
+	}
+
+	fmt.Printf("%s is valid (%d %s)\n", configPath, len(files), core.Pluralize(len(files), "file", "files"))
+	return 0
+}
+
+// importRules converts a gitleaks or trufflehog rule file into signatures and
+// writes them to stdout as YAML, ready to be saved in signatures.d. Anything
+// that could not be converted is reported on stderr.
+func importRules(args []string) int {
+	if len(args) != 2 {
+		fmt.Fprintf(os.Stderr, "Usage: import-rules <%s|%s> <file>\n", core.FormatGitleaks, core.FormatTrufflehog)
+		return 2
+	}
+
+	data, err := ioutil.ReadFile(args[1])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	signatures, warnings, err := core.ImportRules(args[0], data)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	for _, warning := range warnings {
+		color.New(color.FgYellow).Fprintln(os.Stderr, warning)
+	}
+
+	output, err := yaml.Marshal(struct {
+		Signatures []core.ConfigSignature `yaml:"signatures"`
+	}{signatures})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Printf("# Imported from %s (%s)\n", args[1], args[0])
+	os.Stdout.Write(output)
+	fmt.Fprintf(os.Stderr, "Imported %d %s\n", len(signatures), core.Pluralize(len(signatures), "signature", "signatures"))
+
+	return 0
+}
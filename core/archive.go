@@ -0,0 +1,249 @@
+package core
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// ArchiveSeparator joins the path of an archive and the path of an entry
+// inside it, e.g. build/app.jar!/config/application.properties.
+const ArchiveSeparator = "!/"
+
+var zipExtensions = []string{".zip", ".jar", ".war", ".ear", ".whl", ".nupkg", ".apk", ".aar"}
+var tarExtensions = []string{".tar"}
+var tarGzipExtensions = []string{".tar.gz", ".tgz"}
+
+var (
+	errArchiveTooDeep   = errors.New("maximum archive depth reached")
+	errArchiveTooLarge  = errors.New("maximum expanded archive size reached")
+	errArchiveTooMany   = errors.New("maximum number of archive entries reached")
+	errArchiveMalformed = errors.New("unrecognised archive format")
+)
+
+// archiveLimits bounds how much work is done expanding a single top-level
+// archive, so a zip bomb can neither exhaust memory nor stall a worker.
+type archiveLimits struct {
+	maxDepth    int
+	maxEntries  int
+	maxSize     int64
+	maxFileSize int64
+}
+
+type archiveWalker struct {
+	limits   archiveLimits
+	entries  int
+	expanded int64
+	scanner  *Scanner
+	emit     func(MatchFile)
+}
+
+func hasAnySuffix(name string, suffixes []string) bool {
+	name = strings.ToLower(name)
+	for _, suffix := range suffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsArchive reports whether path has the extension of an archive format that
+// can be expanded and scanned.
+func IsArchive(path string) bool {
+	return hasAnySuffix(path, zipExtensions) || hasAnySuffix(path, tarExtensions) || hasAnySuffix(path, tarGzipExtensions)
+}
+
+// NewArchiveMatchFile builds a MatchFile for an entry read from inside an
+// archive. path is the full archive path (using ArchiveSeparator) and name is
+// the entry's path within its innermost archive.
+func NewArchiveMatchFile(path string, name string, contents []byte) MatchFile {
+	name = filepath.ToSlash(name)
+	_, filename := filepath.Split(name)
+
+	return MatchFile{
+		Path:      filepath.ToSlash(path),
+		Filename:  filename,
+		Extension: filepath.Ext(name),
+		Contents:  contents,
+	}
+}
+
+// expandArchive recursively reads the archive in contents and calls emit for
+// every regular file found inside it, within the scanner's depth, size and
+// entry count limits. Entries already emitted before a limit is hit are kept.
+func (s *Scanner) expandArchive(path string, contents []byte, emit func(MatchFile)) error {
+	walker := &archiveWalker{
+		limits: archiveLimits{
+			maxDepth:    int(s.config.ArchiveMaxDepth),
+			maxEntries:  int(s.config.ArchiveMaxEntries),
+			maxSize:     int64(s.config.ArchiveMaxSize) * 1024,
+			maxFileSize: int64(s.config.MaximumFileSize) * 1024,
+		},
+		scanner: s,
+		emit:    emit,
+	}
+
+	return walker.walk(filepath.ToSlash(path), contents, 1)
+}
+
+func (w *archiveWalker) walk(path string, contents []byte, depth int) error {
+	if depth > w.limits.maxDepth {
+		return errArchiveTooDeep
+	}
+
+	switch {
+	case hasAnySuffix(path, zipExtensions):
+		return w.walkZip(path, contents, depth)
+	case hasAnySuffix(path, tarGzipExtensions):
+		gz, err := gzip.NewReader(bytes.NewReader(contents))
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+
+		return w.walkTar(path, gz, depth)
+	case hasAnySuffix(path, tarExtensions):
+		return w.walkTar(path, bytes.NewReader(contents), depth)
+	}
+
+	return errArchiveMalformed
+}
+
+func (w *archiveWalker) walkZip(path string, contents []byte, depth int) error {
+	reader, err := zip.NewReader(bytes.NewReader(contents), int64(len(contents)))
+	if err != nil {
+		return err
+	}
+
+	for _, f := range reader.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+
+		if !w.wants(f.Name, int64(f.UncompressedSize64)) {
+			if err := w.count(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			continue
+		}
+		entry, err := w.read(rc)
+		rc.Close()
+
+		if err != nil {
+			return err
+		}
+
+		if err := w.entry(path, f.Name, entry, depth); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (w *archiveWalker) walkTar(path string, r io.Reader, depth int) error {
+	reader := tar.NewReader(r)
+
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		if !w.wants(header.Name, header.Size) {
+			if err := w.count(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		entry, err := w.read(reader)
+		if err != nil {
+			return err
+		}
+
+		if err := w.entry(path, header.Name, entry, depth); err != nil {
+			return err
+		}
+	}
+}
+
+// wants decides from an entry's header whether it is worth decompressing.
+// The declared size is only a hint; read enforces the real limit.
+func (w *archiveWalker) wants(name string, size int64) bool {
+	if w.scanner.isSkippable(name) {
+		return false
+	}
+
+	if IsArchive(name) {
+		return true
+	}
+
+	return size <= w.limits.maxFileSize
+}
+
+func (w *archiveWalker) count() error {
+	w.entries++
+	if w.entries > w.limits.maxEntries {
+		return errArchiveTooMany
+	}
+
+	return nil
+}
+
+func (w *archiveWalker) read(r io.Reader) ([]byte, error) {
+	if err := w.count(); err != nil {
+		return nil, err
+	}
+
+	remaining := w.limits.maxSize - w.expanded
+	contents, err := ioutil.ReadAll(io.LimitReader(r, remaining+1))
+	w.expanded += int64(len(contents))
+
+	if w.expanded > w.limits.maxSize {
+		return nil, errArchiveTooLarge
+	}
+
+	return contents, err
+}
+
+func (w *archiveWalker) entry(path string, name string, contents []byte, depth int) error {
+	entryPath := fmt.Sprintf("%s%s%s", path, ArchiveSeparator, strings.TrimPrefix(name, "/"))
+
+	if IsArchive(name) {
+		if err := w.walk(entryPath, contents, depth+1); err != nil {
+			if err == errArchiveTooLarge || err == errArchiveTooMany {
+				return err
+			}
+			w.scanner.debug("Skipping nested archive %s: %s", entryPath, err)
+		}
+		return nil
+	}
+
+	if int64(len(contents)) > w.limits.maxFileSize {
+		return nil
+	}
+
+	w.emit(NewArchiveMatchFile(entryPath, name, contents))
+	return nil
+}
@@ -0,0 +1,100 @@
+package core
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// AllowMarker suppresses any finding on the line it appears on, e.g.
+// `password = "hunter2" # aetherkey:allow`.
+const AllowMarker = "aetherkey:allow"
+
+// Baseline is a set of known finding fingerprints. Findings in the baseline
+// are suppressed so that CI runs only fail on new secrets.
+type Baseline struct {
+	sync.Mutex `yaml:"-"`
+
+	path     string
+	salt     string
+	Updated  string          `yaml:"updated,omitempty"`
+	Findings []BaselineEntry `yaml:"findings"`
+	index    map[string]bool
+}
+
+type BaselineEntry struct {
+	Fingerprint string `yaml:"fingerprint"`
+	Signature   string `yaml:"signature"`
+	File        string `yaml:"file,omitempty"`
+	Line        int    `yaml:"line,omitempty"`
+}
+
+// LoadBaseline reads the baseline file at path, whose fingerprints are keyed
+// with salt. A missing file yields an empty baseline that will be created on
+// Save.
+func LoadBaseline(path string, salt string) (*Baseline, error) {
+	baseline := &Baseline{path: path, salt: salt, index: make(map[string]bool)}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return baseline, nil
+	} else if err != nil {
+		return baseline, err
+	}
+
+	if err := yaml.Unmarshal(data, baseline); err != nil {
+		return baseline, fmt.Errorf("invalid baseline %s: %s", path, err)
+	}
+
+	for _, entry := range baseline.Findings {
+		baseline.index[entry.Fingerprint] = true
+	}
+
+	return baseline, nil
+}
+
+func (b *Baseline) Contains(event *MatchEvent) bool {
+	if b == nil {
+		return false
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	return b.index[event.Fingerprint(b.salt)]
+}
+
+func (b *Baseline) Add(event *MatchEvent) {
+	b.Lock()
+	defer b.Unlock()
+
+	fingerprint := event.Fingerprint(b.salt)
+	if b.index[fingerprint] {
+		return
+	}
+
+	b.index[fingerprint] = true
+	b.Findings = append(b.Findings, BaselineEntry{
+		Fingerprint: fingerprint,
+		Signature:   event.Signature,
+		File:        event.File,
+		Line:        event.Line,
+	})
+}
+
+func (b *Baseline) Save() error {
+	b.Lock()
+	defer b.Unlock()
+
+	b.Updated = time.Now().UTC().Format(time.RFC3339)
+	data, err := yaml.Marshal(b)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(b.path, data, 0644)
+}
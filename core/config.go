@@ -2,63 +2,195 @@ package core
 
 import (
 	"errors"
-	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
-
-	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	GitHubAccessTokens           []string          `yaml:"github_access_tokens"`
-	Webhook                      string            `yaml:"webhook,omitempty"`
-	WebhookPayload               string            `yaml:"webhook_payload,omitempty"`
-	BlacklistedStrings           []string          `yaml:"blacklisted_strings"`
-	BlacklistedExtensions        []string          `yaml:"blacklisted_extensions"`
-	BlacklistedPaths             []string          `yaml:"blacklisted_paths"`
-	BlacklistedEntropyExtensions []string          `yaml:"blacklisted_entropy_extensions"`
-	Signatures                   []ConfigSignature `yaml:"signatures"`
+	Include                      []string              `yaml:"include,omitempty"`
+	GitHubAccessTokens           []string              `yaml:"github_access_tokens"`
+	Webhook                      string                `yaml:"webhook,omitempty"`
+	WebhookPayload               string                `yaml:"webhook_payload,omitempty"`
+	CoordinatorToken             string                `yaml:"coordinator_token,omitempty"`
+	ApiToken                     string                `yaml:"api_token,omitempty"`
+	LiveToken                    string                `yaml:"live_token,omitempty"`
+	BlacklistedStrings           []string              `yaml:"blacklisted_strings"`
+	BlacklistedExtensions        []string              `yaml:"blacklisted_extensions"`
+	BlacklistedPaths             []string              `yaml:"blacklisted_paths"`
+	BlacklistedEntropyExtensions []string              `yaml:"blacklisted_entropy_extensions"`
+	SkipBinaryFiles              SkipBinaryFiles       `yaml:"skip_binary_files"`
+	Redaction                    Redaction             `yaml:"redaction"`
+	Signatures                   []ConfigSignature     `yaml:"signatures"`
+	Allowlists                   []ConfigAllowlistRule `yaml:"allowlists,omitempty"`
+
+	// Path is the file the configuration was loaded from and Files every
+	// file merged in to it, in the order they were applied.
+	Path  string   `yaml:"-"`
+	Files []string `yaml:"-"`
 }
 
 type ConfigSignature struct {
-	Name     string `yaml:"name"`
-	Part     string `yaml:"part"`
-	Match    string `yaml:"match,omitempty"`
-	Regex    string `yaml:"regex,omitempty"`
-	Verifier string `yaml:"verifier,omitempty"`
-	Search   string `yaml:"search,omitempty"`
+	Name        string   `yaml:"name"`
+	Part        string   `yaml:"part"`
+	Match       string   `yaml:"match,omitempty"`
+	Regex       string   `yaml:"regex,omitempty"`
+	Verifier    string   `yaml:"verifier,omitempty"`
+	Search      string   `yaml:"search,omitempty"`
+	Severity    string   `yaml:"severity,omitempty"`
+	Tags        []string `yaml:"tags,omitempty"`
+	Description string   `yaml:"description,omitempty"`
+	Remediation string   `yaml:"remediation,omitempty"`
+	Confidence  string   `yaml:"confidence,omitempty"`
+
+	Keywords    []string        `yaml:"keywords,omitempty"`
+	Entropy     float64         `yaml:"entropy,omitempty"`
+	SecretGroup int             `yaml:"secret_group,omitempty"`
+	Allowlist   ConfigAllowlist `yaml:"allowlist,omitempty"`
+
+	ExamplesMatch   []string `yaml:"examples_match,omitempty"`
+	ExamplesNoMatch []string `yaml:"examples_no_match,omitempty"`
 }
 
-func ParseConfig(options *Options) (*Config, error) {
-	config := &Config{}
-	var (
-		data []byte
-		err  error
-	)
+// ConfigAllowlist suppresses matches of a single signature: matches that
+// satisfy any regex, contain any stopword or whose SecretHash is listed in
+// secrets, and files whose path satisfies any path regex.
+type ConfigAllowlist struct {
+	Regexes   []string `yaml:"regexes,omitempty"`
+	Paths     []string `yaml:"paths,omitempty"`
+	Stopwords []string `yaml:"stopwords,omitempty"`
+	Secrets   []string `yaml:"secrets,omitempty"`
+}
 
-	if len(*options.ConfigPath) > 0 {
-		data, err = ioutil.ReadFile(path.Join(*options.ConfigPath, "config.yaml"))
-		if err != nil {
-			return config, err
-		}
-	} else {
-		// Trying to first find the configuration next to executable
-		// Helps e.g. with Drone where workdir is different than shhgit dir
-		ex, err := os.Executable()
-		dir := filepath.Dir(ex)
-		data, err = ioutil.ReadFile(path.Join(dir, "config.yaml"))
-		if err != nil {
-			dir, _ = os.Getwd()
-			data, err = ioutil.ReadFile(path.Join(dir, "config.yaml"))
-			if err != nil {
-				return config, err
+// ConfigAllowlistRule adds to the allowlist of the signature it names, which
+// may be defined in another file. Rules are applied once every file has been
+// merged, so they survive a later file replacing the signature.
+type ConfigAllowlistRule struct {
+	Signature string   `yaml:"signature"`
+	Regexes   []string `yaml:"regexes,omitempty"`
+	Paths     []string `yaml:"paths,omitempty"`
+	Stopwords []string `yaml:"stopwords,omitempty"`
+	Secrets   []string `yaml:"secrets,omitempty"`
+}
+
+// applyAllowlists appends every allowlist rule to its signature's allowlist.
+// Rules naming a signature that is not configured are ignored.
+func (c *Config) applyAllowlists() {
+	for _, rule := range c.Allowlists {
+		for i := range c.Signatures {
+			if c.Signatures[i].Name != rule.Signature {
+				continue
 			}
+
+			allowlist := &c.Signatures[i].Allowlist
+			allowlist.Regexes = append(allowlist.Regexes, rule.Regexes...)
+			allowlist.Paths = append(allowlist.Paths, rule.Paths...)
+			allowlist.Stopwords = append(allowlist.Stopwords, rule.Stopwords...)
+			allowlist.Secrets = append(allowlist.Secrets, rule.Secrets...)
 		}
 	}
+}
+
+// Metadata returns the signature's triage metadata, defaulting severity and
+// confidence to medium when they are not set.
+func (c ConfigSignature) Metadata() SignatureMetadata {
+	metadata := SignatureMetadata{
+		Severity:    strings.ToLower(c.Severity),
+		Tags:        c.Tags,
+		Description: c.Description,
+		Remediation: c.Remediation,
+		Confidence:  strings.ToLower(c.Confidence),
+	}
+
+	if metadata.Severity == "" {
+		metadata.Severity = SeverityMedium
+	}
+
+	if metadata.Confidence == "" {
+		metadata.Confidence = ConfidenceMedium
+	}
+
+	return metadata
+}
+
+// SkipBinaryFiles controls whether files that sniff as binary are skipped,
+// separately for public (GitHub) and local scans.
+type SkipBinaryFiles struct {
+	Public bool `yaml:"public"`
+	Local  bool `yaml:"local"`
+}
+
+// Enabled reports whether binary files should be skipped in the mode selected
+// by options.
+func (s SkipBinaryFiles) Enabled(options *Options) bool {
+	if len(*options.Local) > 0 {
+		return s.Local
+	}
+
+	return s.Public
+}
+
+// FindConfigPath returns the config.yaml to use: the one in --config-path if
+// given, otherwise the one next to the executable or in the working directory.
+func FindConfigPath(options *Options) (string, error) {
+	if len(*options.ConfigPath) > 0 {
+		configPath := path.Join(*options.ConfigPath, "config.yaml")
+		_, err := os.Stat(configPath)
+		return configPath, err
+	}
 
-	err = yaml.Unmarshal(data, config)
+	// Trying to first find the configuration next to executable
+	// Helps e.g. with Drone where workdir is different than shhgit dir
+	ex, err := os.Executable()
+	configPath := path.Join(filepath.Dir(ex), "config.yaml")
+	if _, err = os.Stat(configPath); err != nil {
+		dir, _ := os.Getwd()
+		configPath = path.Join(dir, "config.yaml")
+		_, err = os.Stat(configPath)
+	}
+
+	return configPath, err
+}
+
+// LoadConfig finds and parses config.yaml without validating that it is
+// usable for a scan, e.g. for commands that only need the signatures.
+func LoadConfig(options *Options) (*Config, error) {
+	config := &Config{}
+
+	configPath, err := FindConfigPath(options)
+	if err != nil {
+		return config, err
+	}
+
+	*config = defaultConfig()
+	if err := loadConfigFile(config, configPath); err != nil {
+		return config, err
+	}
+
+	config.Path = configPath
+	return config, nil
+}
+
+// loadConfigFile merges the signature packs next to the configuration file at
+// path, then the file and its includes, in to config and applies the
+// allowlist rules.
+func loadConfigFile(config *Config, path string) error {
+	var implicit []string
+	if packs := filepath.Join(filepath.Dir(path), SignaturePacksDir); PathExists(packs) {
+		implicit = append(implicit, packs)
+	}
+
+	if err := newConfigLoader(config).load(path, implicit); err != nil {
+		return err
+	}
+
+	config.applyAllowlists()
+	return nil
+}
+
+func ParseConfig(options *Options) (*Config, error) {
+	config, err := LoadConfig(options)
 	if err != nil {
 		return config, err
 	}
@@ -66,6 +198,14 @@ func ParseConfig(options *Options) (*Config, error) {
 	for i := 0; i < len(config.GitHubAccessTokens); i++ {
 		config.GitHubAccessTokens[i] = os.ExpandEnv(config.GitHubAccessTokens[i])
 	}
+	config.CoordinatorToken = os.ExpandEnv(config.CoordinatorToken)
+	config.ApiToken = os.ExpandEnv(config.ApiToken)
+	config.LiveToken = os.ExpandEnv(config.LiveToken)
+	config.Redaction.Salt = os.ExpandEnv(config.Redaction.Salt)
+
+	if len(*options.Local) > 0 || len(*options.Serve) > 0 {
+		return config, nil
+	}
 
 	if len(config.GitHubAccessTokens) < 1 || strings.TrimSpace(strings.Join(config.GitHubAccessTokens, "")) == "" {
 		return config, errors.New("You need to provide at least one GitHub Access Token. See https://help.github.com/en/articles/creating-a-personal-access-token-for-the-command-line")
@@ -74,8 +214,15 @@ func ParseConfig(options *Options) (*Config, error) {
 	return config, nil
 }
 
+func defaultConfig() Config {
+	return Config{
+		SkipBinaryFiles: SkipBinaryFiles{Public: true, Local: true},
+		Redaction:       Redaction{Mode: RedactMask, Prefix: 4, Suffix: 4},
+	}
+}
+
 func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
-	*c = Config{}
+	*c = defaultConfig()
 	type plain Config
 
 	err := unmarshal((*plain)(c))
@@ -0,0 +1,359 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// leaseTimeout is how long a worker has to complete a lease, or send a
+	// heartbeat extending it, before the work is handed to another worker.
+	leaseTimeout = 2 * time.Minute
+
+	// leaseMaxAttempts is how many times an item is leased before it is
+	// dropped as failing.
+	leaseMaxAttempts = 3
+
+	// leaseWait is how long a lease request waits for work before the
+	// coordinator answers that there is none.
+	leaseWait = 20 * time.Second
+
+	// maxLeaseReportSize bounds the body of a heartbeat, completion or
+	// failure, findings included.
+	maxLeaseReportSize = 32 * 1024 * 1024
+)
+
+// Lease is an item of work handed to a worker by the coordinator.
+type Lease struct {
+	Id      string          `json:"id"`
+	Queue   string          `json:"queue"`
+	Item    json.RawMessage `json:"item"`
+	Attempt int             `json:"attempt"`
+	Expires time.Time       `json:"expires"`
+}
+
+// LeaseReport is what a worker sends back when it completes or fails a
+// lease.
+type LeaseReport struct {
+	Findings []*MatchEvent `json:"findings,omitempty"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// CoordinatorStatus describes the coordinator's queues and leases.
+type CoordinatorStatus struct {
+	Queues  map[string]int `json:"queues"`
+	Leases  int            `json:"leases"`
+	Workers []string       `json:"workers"`
+}
+
+type activeLease struct {
+	Lease
+	worker string
+	queue  *Queue
+	ticket Ticket
+}
+
+// Coordinator hands the items in the session's work queues out to workers
+// over HTTP. An item stays in flight in its queue until the lease on it is
+// completed, so work leased when the coordinator stops is handed out again
+// after a restart.
+type Coordinator struct {
+	sync.Mutex
+
+	session  *Session
+	publish  func(*MatchEvent)
+	leases   map[string]*activeLease
+	attempts map[string]int
+}
+
+// NewCoordinator returns a coordinator for the session's queues. Findings
+// reported by workers are passed to publish.
+func NewCoordinator(session *Session, publish func(*MatchEvent)) *Coordinator {
+	return &Coordinator{
+		session:  session,
+		publish:  publish,
+		leases:   make(map[string]*activeLease),
+		attempts: make(map[string]int),
+	}
+}
+
+// Serve serves the lease API on listener until the session stops.
+func (c *Coordinator) Serve(listener net.Listener) error {
+
+	go c.reap()
+
+	return serveUntilDone(c.session.Context, listener, c)
+}
+
+// ServeHTTP implements the lease API:
+//
+//	POST /v1/leases?worker=ID          lease the next item, 204 if there is none
+//	POST /v1/leases/ID/heartbeat       extend a lease
+//	POST /v1/leases/ID/complete        report findings and finish a lease
+//	POST /v1/leases/ID/fail            give a lease back to be retried
+//	GET  /v1/status                    queue lengths and active leases
+func (c *Coordinator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !bearerAuthorized(r, c.session.CurrentConfig().CoordinatorToken) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	path := strings.Trim(r.URL.Path, "/")
+	parts := strings.Split(path, "/")
+
+	switch {
+	case path == "v1/status" && r.Method == http.MethodGet:
+		writeJSON(w, c.Status())
+	case path == "v1/leases" && r.Method == http.MethodPost:
+		lease, err := c.Lease(r.Context(), r.URL.Query().Get("worker"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		} else if lease == nil {
+			w.WriteHeader(http.StatusNoContent)
+		} else {
+			writeJSON(w, lease)
+		}
+	case len(parts) == 4 && parts[0] == "v1" && parts[1] == "leases" && r.Method == http.MethodPost:
+		var report LeaseReport
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxLeaseReportSize)).Decode(&report); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		var (
+			lease *Lease
+			found bool
+		)
+		switch parts[3] {
+		case "heartbeat":
+			lease, found = c.Heartbeat(parts[2])
+		case "complete":
+			found = c.Complete(parts[2], report.Findings)
+		case "fail":
+			found = c.Fail(parts[2], report.Error)
+		default:
+			http.NotFound(w, r)
+			return
+		}
+
+		if !found {
+			http.Error(w, "unknown or expired lease", http.StatusNotFound)
+		} else if lease != nil {
+			writeJSON(w, lease)
+		} else {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// Lease hands the next queued item to worker, waiting up to leaseWait for
+// one. It returns nil if there is no work.
+func (c *Coordinator) Lease(ctx context.Context, worker string) (*Lease, error) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	deadline := time.After(leaseWait)
+
+	for {
+		for _, queue := range c.session.queues() {
+			item, ticket, ok, err := (*queue.queue).TryPop()
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+
+			return c.lease(worker, queue.name, *queue.queue, item, ticket)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-deadline:
+			return nil, nil
+		case <-ctx.Done():
+			return nil, nil
+		case <-c.session.Context.Done():
+			return nil, nil
+		}
+	}
+}
+
+// lease records item as leased to worker. Lease IDs are random, so that an ID
+// from before a restart can never complete a lease handed out after it.
+func (c *Coordinator) lease(worker string, name string, queue *Queue, item json.RawMessage, ticket Ticket) (*Lease, error) {
+	id, err := randomId()
+	if err != nil {
+		return nil, err
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	active := &activeLease{
+		Lease: Lease{
+			Id:      id,
+			Queue:   name,
+			Item:    item,
+			Attempt: c.attempts[attemptKey(name, item)] + 1,
+			Expires: time.Now().Add(leaseTimeout),
+		},
+		worker: worker,
+		queue:  queue,
+		ticket: ticket,
+	}
+	c.leases[active.Id] = active
+
+	c.session.Log.Debug("Leased %s item %s to %s (attempt %d)", name, active.Id, worker, active.Attempt)
+	lease := active.Lease
+	return &lease, nil
+}
+
+// Heartbeat extends the lease with id, returning false if it expired.
+func (c *Coordinator) Heartbeat(id string) (*Lease, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	active, exists := c.leases[id]
+	if !exists {
+		return nil, false
+	}
+
+	active.Expires = time.Now().Add(leaseTimeout)
+	lease := active.Lease
+	return &lease, true
+}
+
+// Complete publishes the findings for the lease with id and marks its item
+// as done, returning false if the lease expired.
+func (c *Coordinator) Complete(id string, findings []*MatchEvent) bool {
+	active := c.remove(id)
+	if active == nil {
+		return false
+	}
+
+	for _, event := range findings {
+		c.publish(event)
+	}
+
+	c.Lock()
+	delete(c.attempts, attemptKey(active.Queue, active.Item))
+	c.Unlock()
+
+	c.session.Ack(active.queue, active.ticket)
+	return true
+}
+
+// Fail gives the lease with id back, returning false if it expired. The item
+// is queued again unless it has been tried leaseMaxAttempts times.
+func (c *Coordinator) Fail(id string, reason string) bool {
+	active := c.remove(id)
+	if active == nil {
+		return false
+	}
+
+	c.retry(active, reason)
+	return true
+}
+
+// Status describes the coordinator's queues and leases.
+func (c *Coordinator) Status() CoordinatorStatus {
+	status := CoordinatorStatus{Queues: make(map[string]int), Workers: []string{}}
+	for _, queue := range c.session.queues() {
+		status.Queues[queue.name] = (*queue.queue).Len()
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	workers := make(map[string]bool)
+	for _, active := range c.leases {
+		if !workers[active.worker] {
+			workers[active.worker] = true
+			status.Workers = append(status.Workers, active.worker)
+		}
+	}
+	sort.Strings(status.Workers)
+
+	status.Leases = len(c.leases)
+	return status
+}
+
+func (c *Coordinator) remove(id string) *activeLease {
+	c.Lock()
+	defer c.Unlock()
+
+	active, exists := c.leases[id]
+	if !exists {
+		return nil
+	}
+
+	delete(c.leases, id)
+	return active
+}
+
+func (c *Coordinator) retry(active *activeLease, reason string) {
+	key := attemptKey(active.Queue, active.Item)
+
+	c.Lock()
+	c.attempts[key] = active.Attempt
+	c.Unlock()
+
+	if active.Attempt >= leaseMaxAttempts {
+		c.session.Log.Warn("Dropping %s item after %d attempts: %s", active.Queue, active.Attempt, reason)
+		c.Lock()
+		delete(c.attempts, key)
+		c.Unlock()
+	} else {
+		c.session.Log.Debug("Retrying %s item leased to %s: %s", active.Queue, active.worker, reason)
+		if err := active.queue.Push(active.Item); err != nil {
+			c.session.Log.Warn("Could not requeue %s item: %s", active.Queue, err)
+			return
+		}
+	}
+
+	c.session.Ack(active.queue, active.ticket)
+}
+
+// reap retries the items of leases that were neither completed nor extended
+// in time, e.g. because the worker died.
+func (c *Coordinator) reap() {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.session.Context.Done():
+			return
+		case now := <-ticker.C:
+			var expired []*activeLease
+
+			c.Lock()
+			for id, active := range c.leases {
+				if now.After(active.Expires) {
+					expired = append(expired, active)
+					delete(c.leases, id)
+				}
+			}
+			c.Unlock()
+
+			for _, active := range expired {
+				c.retry(active, fmt.Sprintf("lease expired on %s", active.worker))
+			}
+		}
+	}
+}
+
+func attemptKey(queue string, item json.RawMessage) string {
+	return queue + ":" + GetHash(string(item))
+}
@@ -0,0 +1,103 @@
+package core
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// minimumPrintableRatio is the share of printable characters decoded data
+// must have to be treated as text worth matching against.
+const minimumPrintableRatio = 0.95
+
+var (
+	base64Run = regexp.MustCompile(`[A-Za-z0-9+/\-_]{20,}={0,2}`)
+	hexRun    = regexp.MustCompile(`^(?:[0-9a-fA-F]{2}){10,}$`)
+
+	base64Encodings = []*base64.Encoding{
+		base64.StdEncoding,
+		base64.URLEncoding,
+		base64.RawStdEncoding,
+		base64.RawURLEncoding,
+	}
+)
+
+// DecodedBlob is text recovered from an encoded run in a file's contents.
+// Chain lists the encodings that were unwrapped, outermost first, and Offset
+// is where the outermost encoded run starts in the original contents.
+type DecodedBlob struct {
+	Chain    []string
+	Offset   int
+	Contents []byte
+}
+
+func (b DecodedBlob) ChainString() string {
+	return strings.Join(b.Chain, " > ")
+}
+
+// DecodeBlobs finds long base64 and hex runs in contents and decodes them,
+// recursing in to the decoded text up to depth times. Only runs that decode
+// to printable text are returned.
+func DecodeBlobs(contents []byte, depth int) []DecodedBlob {
+	var blobs []DecodedBlob
+	if depth <= 0 {
+		return blobs
+	}
+
+	for _, loc := range base64Run.FindAllIndex(contents, -1) {
+		run := contents[loc[0]:loc[1]]
+		decoded, encoding := decodeRun(run)
+		if decoded == nil {
+			continue
+		}
+
+		blobs = append(blobs, DecodedBlob{Chain: []string{encoding}, Offset: loc[0], Contents: decoded})
+
+		for _, inner := range DecodeBlobs(decoded, depth-1) {
+			blobs = append(blobs, DecodedBlob{
+				Chain:    append([]string{encoding}, inner.Chain...),
+				Offset:   loc[0],
+				Contents: inner.Contents,
+			})
+		}
+	}
+
+	return blobs
+}
+
+func decodeRun(run []byte) ([]byte, string) {
+	if hexRun.Match(run) {
+		decoded := make([]byte, hex.DecodedLen(len(run)))
+		if _, err := hex.Decode(decoded, run); err == nil && isPrintable(decoded) {
+			return decoded, "hex"
+		}
+	}
+
+	for _, encoding := range base64Encodings {
+		decoded := make([]byte, encoding.DecodedLen(len(run)))
+		n, err := encoding.Decode(decoded, run)
+		if err == nil && isPrintable(decoded[:n]) {
+			return decoded[:n], "base64"
+		}
+	}
+
+	return nil, ""
+}
+
+func isPrintable(data []byte) bool {
+	if len(data) == 0 || !utf8.Valid(data) {
+		return false
+	}
+
+	printable, total := 0, 0
+	for _, r := range string(data) {
+		total++
+		if r == '\n' || r == '\r' || r == '\t' || (r >= 0x20 && r != 0x7f) {
+			printable++
+		}
+	}
+
+	return float64(printable)/float64(total) >= minimumPrintableRatio
+}
@@ -0,0 +1,119 @@
+package core
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+var (
+	scpLikeUrl     = regexp.MustCompile(`^[\w.\-]+@([\w.\-]+):(.+)$`)
+	githubBlobPath = regexp.MustCompile(`^(github\.com/[^/]+/[^/]+)/(?:blob|raw)/[^/]+/(.+)$`)
+	githubRawPath  = regexp.MustCompile(`^raw\.githubusercontent\.com/([^/]+/[^/]+)/[^/]+/(.+)$`)
+)
+
+// NormalizeRepository reduces the many URL forms a repository or file can be
+// reached by (clone URL, blob URL, raw URL, SSH remote) to a canonical
+// host/owner/name, plus the file path when the URL points at a file.
+func NormalizeRepository(url string) (repository string, path string) {
+	url = strings.TrimSpace(url)
+	if m := scpLikeUrl.FindStringSubmatch(url); m != nil {
+		url = m[1] + "/" + m[2]
+	}
+
+	if idx := strings.Index(url, "://"); idx != -1 {
+		url = url[idx+3:]
+	}
+	if idx := strings.Index(url, "@"); idx != -1 && idx < strings.Index(url+"/", "/") {
+		url = url[idx+1:]
+	}
+	if idx := strings.IndexAny(url, "?#"); idx != -1 {
+		url = url[:idx]
+	}
+	url = strings.TrimSuffix(strings.TrimSuffix(url, "/"), ".git")
+
+	if m := githubRawPath.FindStringSubmatch(url); m != nil {
+		return "github.com/" + strings.ToLower(m[1]), m[2]
+	}
+	if m := githubBlobPath.FindStringSubmatch(url); m != nil {
+		return strings.ToLower(m[1]), m[2]
+	}
+
+	return strings.ToLower(url), ""
+}
+
+// Fingerprint identifies a finding independently of where and when it was
+// found: the same secret in the same file of the same repository always has
+// the same fingerprint, whether it came from code search or a clone, and
+// regardless of the line it moved to. Local scans leave the repository out so
+// a checkout's location does not matter.
+//
+// The match is hashed with salt, the redaction salt, so a fingerprint cannot
+// be used to confirm a guessed secret without it, and fingerprints only
+// compare between machines that share the salt.
+func (e *MatchEvent) Fingerprint(salt string) string {
+	repository, path := "", ""
+	if e.Source != LOCAL_SOURCE {
+		repository, path = NormalizeRepository(e.Url)
+	}
+
+	if e.File != "" {
+		path = e.File
+	}
+	path = strings.TrimPrefix(filepath.ToSlash(path), "/")
+
+	secret := hmac.New(sha256.New, []byte(salt))
+	secret.Write([]byte(e.Match))
+	h := sha256.New()
+	h.Write([]byte(strings.Join([]string{e.Signature, repository, path, hex.EncodeToString(secret.Sum(nil))}, "\x00")))
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Fingerprint returns event's fingerprint, keyed with the session's
+// redaction salt.
+func (s *Session) Fingerprint(event *MatchEvent) string {
+	return event.Fingerprint(s.CurrentConfig().Redaction.Salt)
+}
+
+// FingerprintIndex is a concurrency-safe set of finding fingerprints.
+type FingerprintIndex struct {
+	sync.Mutex
+
+	fingerprints map[string]bool
+}
+
+func NewFingerprintIndex() *FingerprintIndex {
+	return &FingerprintIndex{fingerprints: make(map[string]bool)}
+}
+
+// Add records fingerprint and reports whether it had not been seen before.
+func (i *FingerprintIndex) Add(fingerprint string) bool {
+	i.Lock()
+	defer i.Unlock()
+
+	if i.fingerprints[fingerprint] {
+		return false
+	}
+
+	i.fingerprints[fingerprint] = true
+	return true
+}
+
+func (i *FingerprintIndex) Contains(fingerprint string) bool {
+	i.Lock()
+	defer i.Unlock()
+
+	return i.fingerprints[fingerprint]
+}
+
+func (i *FingerprintIndex) Len() int {
+	i.Lock()
+	defer i.Unlock()
+
+	return len(i.fingerprints)
+}
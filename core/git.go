@@ -20,6 +20,25 @@ const (
 	GITLAB_SOURCE
 )
 
+func (t GitResourceType) String() string {
+	switch t {
+	case LOCAL_SOURCE:
+		return "local"
+	case GITHUB_SOURCE:
+		return "github"
+	case GITHUB_COMMENT:
+		return "comment"
+	case GIST_SOURCE:
+		return "gist"
+	case BITBUCKET_SOURCE:
+		return "bitbucket"
+	case GITLAB_SOURCE:
+		return "gitlab"
+	}
+
+	return "unknown"
+}
+
 type GitResource struct {
 	Id   int64
 	Type GitResourceType
@@ -29,10 +48,26 @@ type GitResource struct {
 
 func CloneRepository(session *Session, url string, ref string, dir string) (*git.Repository, error) {
 	timeout := time.Duration(*session.Options.CloneRepositoryTimeout) * time.Second
-	localCtx, cancel := context.WithTimeout(context.Background(), timeout)
-	defer cancel()
 
 	session.Log.Debug("[%s] Cloning %s in to %s", url, ref, strings.Replace(dir, *session.Options.TempDirectory, "", -1))
+	repository, err := cloneRepository(session.Context, url, ref, dir, timeout)
+
+	if err != nil {
+		session.Log.Debug("[%s] Cloning failed: %s", url, err.Error())
+		session.Stats.IncReposFailed()
+		return nil, err
+	}
+
+	session.Stats.IncReposCloned()
+	return repository, nil
+}
+
+// cloneRepository makes a shallow, single branch clone of url at ref (or the
+// default branch) in to dir.
+func cloneRepository(ctx context.Context, url string, ref string, dir string, timeout time.Duration) (*git.Repository, error) {
+	localCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
 	opts := &git.CloneOptions{
 		Depth:             1,
 		RecurseSubmodules: git.NoRecurseSubmodules,
@@ -45,12 +80,5 @@ func CloneRepository(session *Session, url string, ref string, dir string) (*git
 		opts.ReferenceName = plumbing.ReferenceName(ref)
 	}
 
-	repository, err := git.PlainCloneContext(localCtx, dir, false, opts)
-
-	if err != nil {
-		session.Log.Debug("[%s] Cloning failed: %s", url, err.Error())
-		return nil, err
-	}
-
-	return repository, nil
+	return git.PlainCloneContext(localCtx, dir, false, opts)
 }
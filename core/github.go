@@ -4,6 +4,9 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -21,6 +24,30 @@ const (
 	sleep   = 30 * time.Second
 )
 
+// rateLimitTransport records the rate limit GitHub reports on every API
+// response for a token.
+type rateLimitTransport struct {
+	base  http.RoundTripper
+	token string
+	stats *Stats
+}
+
+func (t *rateLimitTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	response, err := t.base.RoundTrip(request)
+	if err != nil {
+		return response, err
+	}
+
+	limit, limitErr := strconv.Atoi(response.Header.Get("X-RateLimit-Limit"))
+	remaining, remainingErr := strconv.Atoi(response.Header.Get("X-RateLimit-Remaining"))
+	reset, resetErr := strconv.ParseInt(response.Header.Get("X-RateLimit-Reset"), 10, 64)
+	if limitErr == nil && remainingErr == nil && resetErr == nil {
+		t.stats.SetRateLimit(t.token, RateLimit{Limit: limit, Remaining: remaining, Reset: time.Unix(reset, 0)})
+	}
+
+	return response, nil
+}
+
 func processGitHubError(client *GitHubClientWrapper, resp *github.Response, err error) bool {
 	if err == nil {
 		return false
@@ -50,7 +77,7 @@ func Search(session *Session) {
 	var client *GitHubClientWrapper
 
 	for c := time.Tick(sleep); ; {
-		for _, signature := range session.Signatures {
+		for _, signature := range session.CurrentSignatures() {
 			if len(signature.Search()) > 0 {
 
 				if client != nil {
@@ -62,6 +89,9 @@ func Search(session *Session) {
 				options.ListOptions.Page = 1
 				for keepSearching := true; keepSearching == true; {
 					result, resp, err := client.Search.Code(localCtx, signature.Search(), &options)
+					if localCtx.Err() != nil {
+						return
+					}
 
 					if processGitHubError(client, resp, err) {
 						continue
@@ -71,7 +101,7 @@ func Search(session *Session) {
 						url := r.GetHTMLURL()
 						rawUrl := strings.Replace(url, "github.com", "raw.githubusercontent.com", 1)
 						rawUrl = strings.Replace(rawUrl, "/blob/", "/", 1)
-						session.SearchResults <- SearchResult{Signature: signature, Url: url, RawUrl: rawUrl}
+						session.EnqueueSearchResult(SearchResult{Signature: signature, Url: url, RawUrl: rawUrl})
 					}
 
 					if len(result.CodeResults) > 0 {
@@ -99,11 +129,15 @@ func GetRepositories(session *Session) {
 	localCtx, cancel := context.WithCancel(session.Context)
 	defer cancel()
 
-	observedKeys := map[string]bool{}
+	// events at or below the cursor were handled before a restart
+	eventCursor, _ := strconv.ParseInt(session.Cursors.Get("events"), 10, 64)
 	var client *GitHubClientWrapper
 
 	for c := time.Tick(sleep); ; {
 		opt := &github.ListOptions{PerPage: perPage}
+		newestEvent := eventCursor
+		complete := false
+		dropped := false
 
 		for {
 			if client != nil {
@@ -112,6 +146,9 @@ func GetRepositories(session *Session) {
 
 			client = session.GetClient()
 			events, resp, err := client.Activity.ListEvents(localCtx, opt)
+			if localCtx.Err() != nil {
+				return
+			}
 
 			if err != nil {
 				if _, ok := err.(*github.RateLimitError); ok {
@@ -138,45 +175,57 @@ func GetRepositories(session *Session) {
 				}
 			}
 
+			session.Stats.AddEventsPolled(len(events))
 			newEvents := make([]*github.Event, 0, len(events))
 
 			// remove duplicates
 			for _, e := range events {
-				if observedKeys[*e.ID] {
+				id, _ := strconv.ParseInt(e.GetID(), 10, 64)
+				if id <= eventCursor || session.Seen.Contains("event:"+e.GetID()) {
 					continue
 				}
 
+				if id > newestEvent {
+					newestEvent = id
+				}
+
 				newEvents = append(newEvents, e)
 			}
 
 			for _, e := range newEvents {
+				var err error
 				if *e.Type == "PushEvent" {
-					observedKeys[*e.ID] = true
-
 					dst := &github.PushEvent{}
 					json.Unmarshal(e.GetRawPayload(), dst)
-					session.Repositories <- GitResource{
+					err = session.Enqueue(session.Repositories, GitResource{
 						Id:   e.GetRepo().GetID(),
 						Type: GITHUB_SOURCE,
 						Url:  e.GetRepo().GetURL(),
 						Ref:  dst.GetRef(),
-					}
+					})
 				} else if *e.Type == "IssueCommentEvent" {
-					observedKeys[*e.ID] = true
-
 					dst := &github.IssueCommentEvent{}
 					json.Unmarshal(e.GetRawPayload(), dst)
-					session.Comments <- *dst.Comment.Body
+					err = session.Enqueue(session.Comments, dst.GetComment().GetBody())
 				} else if *e.Type == "IssuesEvent" {
-					observedKeys[*e.ID] = true
-
 					dst := &github.IssuesEvent{}
 					json.Unmarshal(e.GetRawPayload(), dst)
-					session.Comments <- dst.Issue.GetBody()
+					err = session.Enqueue(session.Comments, dst.Issue.GetBody())
+				} else {
+					continue
 				}
+
+				// a dropped event stays unseen and holds the cursor back so
+				// it is picked up again on a later poll
+				if err != nil {
+					dropped = true
+					continue
+				}
+				session.Seen.Add("event:" + e.GetID())
 			}
 
 			if resp.NextPage == 0 {
+				complete = err == nil
 				break
 			}
 
@@ -184,6 +233,15 @@ func GetRepositories(session *Session) {
 			time.Sleep(5 * time.Second)
 		}
 
+		// older events on the pages that were not read must not fall behind the
+		// cursor, so it only moves after every page was read and queued
+		if complete && !dropped && newestEvent > eventCursor {
+			eventCursor = newestEvent
+			if err := session.Cursors.Set("events", strconv.FormatInt(eventCursor, 10)); err != nil {
+				session.Log.Warn("Could not save the events cursor: %s", err)
+			}
+		}
+
 		select {
 		case <-c:
 			continue
@@ -198,8 +256,10 @@ func GetGists(session *Session) {
 	localCtx, cancel := context.WithCancel(session.Context)
 	defer cancel()
 
-	observedKeys := map[string]bool{}
 	opt := &github.GistListOptions{}
+	if since, err := time.Parse(time.RFC3339, session.Cursors.Get("gists")); err == nil {
+		opt.Since = since
+	}
 
 	var client *GitHubClientWrapper
 	for c := time.Tick(sleep); ; {
@@ -208,7 +268,11 @@ func GetGists(session *Session) {
 		}
 
 		client = session.GetClient()
+		polled := time.Now()
 		gists, resp, err := client.Gists.ListAll(localCtx, opt)
+		if localCtx.Err() != nil {
+			return
+		}
 
 		if err != nil {
 			if _, ok := err.(*github.RateLimitError); ok {
@@ -225,21 +289,34 @@ func GetGists(session *Session) {
 			session.Log.Warn("Error getting GitHub Gists: %s ... trying again", err)
 		}
 
+		session.Stats.AddGistsPolled(len(gists))
 		newGists := make([]*github.Gist, 0, len(gists))
 		for _, e := range gists {
-			if observedKeys[*e.ID] {
+			if session.Seen.Contains("gist:" + e.GetID()) {
 				continue
 			}
 
 			newGists = append(newGists, e)
 		}
 
+		dropped := false
 		for _, e := range newGists {
-			observedKeys[*e.ID] = true
-			session.Gists <- *e.GitPullURL
+			if session.Enqueue(session.Gists, e.GetGitPullURL()) != nil {
+				dropped = true
+				continue
+			}
+			session.Seen.Add("gist:" + e.GetID())
 		}
 
-		opt.Since = time.Now()
+		// gists updated while the request was in flight are listed again next
+		// time, and a failed request or a full queue is retried from the same
+		// point
+		if err == nil && !dropped {
+			opt.Since = polled
+			if err := session.Cursors.Set("gists", opt.Since.Format(time.RFC3339)); err != nil {
+				session.Log.Warn("Could not save the gists cursor: %s", err)
+			}
+		}
 
 		select {
 		case <-c:
@@ -251,6 +328,55 @@ func GetGists(session *Session) {
 	}
 }
 
+var gistAnchorReplacer = regexp.MustCompile(`[^a-z0-9]+`)
+
+// GetPermalink builds a link to line of file for GitHub and Gist URLs, either
+// a blob URL from code search or the clone URL of a repository. It returns an
+// empty string for any other source.
+func GetPermalink(url string, ref string, file string, line int) string {
+	anchor := ""
+	if line > 0 {
+		anchor = fmt.Sprintf("L%d", line)
+	}
+
+	if strings.Contains(url, "github.com/") && strings.Contains(url, "/blob/") {
+		if anchor == "" {
+			return url
+		}
+		return fmt.Sprintf("%s#%s", url, anchor)
+	}
+
+	if idx := strings.Index(file, ArchiveSeparator); idx != -1 {
+		file = file[:idx]
+		anchor = ""
+	}
+	file = strings.TrimPrefix(file, "/")
+	base := strings.TrimSuffix(url, ".git")
+
+	if strings.HasPrefix(base, "https://gist.github.com/") {
+		fileAnchor := "file-" + strings.Trim(gistAnchorReplacer.ReplaceAllString(strings.ToLower(file), "-"), "-")
+		if anchor != "" {
+			fileAnchor = fmt.Sprintf("%s-%s", fileAnchor, anchor)
+		}
+		return fmt.Sprintf("%s#%s", base, fileAnchor)
+	}
+
+	if strings.HasPrefix(base, "https://github.com/") {
+		if ref == "" {
+			ref = "HEAD"
+		}
+		ref = strings.TrimPrefix(strings.TrimPrefix(ref, "refs/heads/"), "refs/tags/")
+
+		permalink := fmt.Sprintf("%s/blob/%s/%s", base, ref, file)
+		if anchor != "" {
+			permalink = fmt.Sprintf("%s#%s", permalink, anchor)
+		}
+		return permalink
+	}
+
+	return ""
+}
+
 func GetRepository(session *Session, id int64) (*github.Repository, error) {
 	client := session.GetClient()
 	defer session.FreeClient(client)
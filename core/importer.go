@@ -0,0 +1,330 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	FormatGitleaks   = "gitleaks"
+	FormatTrufflehog = "trufflehog"
+)
+
+// gitleaksConfig covers the rule files of gitleaks v7 and v8. Fields that
+// have no equivalent here (commits, stopwords on the global allowlist in v7,
+// report groups) are read so they can be reported, not silently dropped.
+type gitleaksConfig struct {
+	Title     string             `toml:"title"`
+	Rules     []gitleaksRule     `toml:"rules"`
+	Allowlist *gitleaksAllowlist `toml:"allowlist"`
+}
+
+type gitleaksRule struct {
+	ID          string              `toml:"id"`
+	Description string              `toml:"description"`
+	Regex       string              `toml:"regex"`
+	SecretGroup int                 `toml:"secretGroup"`
+	Entropy     float64             `toml:"entropy"`
+	Keywords    []string            `toml:"keywords"`
+	Path        string              `toml:"path"`
+	File        string              `toml:"file"`
+	Tags        []string            `toml:"tags"`
+	Allowlist   *gitleaksAllowlist  `toml:"allowlist"`
+	Allowlists  []gitleaksAllowlist `toml:"allowlists"`
+	Entropies   []gitleaksEntropy   `toml:"Entropies"`
+}
+
+type gitleaksAllowlist struct {
+	Description string   `toml:"description"`
+	Condition   string   `toml:"condition"`
+	RegexTarget string   `toml:"regexTarget"`
+	Regexes     []string `toml:"regexes"`
+	Paths       []string `toml:"paths"`
+	Files       []string `toml:"files"`
+	Stopwords   []string `toml:"stopwords"`
+	Commits     []string `toml:"commits"`
+}
+
+type gitleaksEntropy struct {
+	Min   string `toml:"Min"`
+	Max   string `toml:"Max"`
+	Group string `toml:"Group"`
+}
+
+// trufflehogConfig is the custom detector file of trufflehog v3.
+type trufflehogConfig struct {
+	Detectors []trufflehogDetector `yaml:"detectors"`
+}
+
+type trufflehogDetector struct {
+	Name                  string            `yaml:"name"`
+	Keywords              []string          `yaml:"keywords"`
+	Regex                 map[string]string `yaml:"regex"`
+	Entropy               float64           `yaml:"entropy"`
+	ExcludeWords          []string          `yaml:"exclude_words"`
+	ExcludeRegexesMatch   []string          `yaml:"exclude_regexes_match"`
+	ExcludeRegexesCapture []string          `yaml:"exclude_regexes_capture"`
+	Verify                []interface{}     `yaml:"verify"`
+}
+
+// ImportRules converts a gitleaks or trufflehog rule file into signatures.
+// Parts of a rule that cannot be expressed as a signature are dropped and
+// described in the returned warnings.
+func ImportRules(format string, data []byte) ([]ConfigSignature, []string, error) {
+	switch format {
+	case FormatGitleaks:
+		return ImportGitleaks(data)
+	case FormatTrufflehog:
+		return ImportTrufflehog(data)
+	}
+
+	return nil, nil, fmt.Errorf("unknown rule format %q, use %s or %s", format, FormatGitleaks, FormatTrufflehog)
+}
+
+// ImportGitleaks converts a gitleaks TOML configuration. The global
+// allowlist is merged in to every rule.
+func ImportGitleaks(data []byte) ([]ConfigSignature, []string, error) {
+	var config gitleaksConfig
+	if _, err := toml.Decode(string(data), &config); err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		signatures []ConfigSignature
+		warnings   []string
+	)
+
+	for i, rule := range config.Rules {
+		name := rule.ID
+		if name == "" {
+			name = rule.Description
+		}
+		if name == "" {
+			name = fmt.Sprintf("gitleaks rule %d", i+1)
+		}
+		warn := func(format string, args ...interface{}) {
+			warnings = append(warnings, fmt.Sprintf("%s: %s", name, fmt.Sprintf(format, args...)))
+		}
+
+		path := rule.Path
+		if path == "" {
+			path = rule.File
+		}
+
+		signature := ConfigSignature{
+			Name:        name,
+			Part:        PartContents,
+			Regex:       rule.Regex,
+			Description: rule.Description,
+			Keywords:    rule.Keywords,
+			Entropy:     rule.Entropy,
+			SecretGroup: rule.SecretGroup,
+			Tags:        append([]string{"gitleaks"}, rule.Tags...),
+		}
+
+		switch {
+		case rule.Regex == "" && path == "":
+			warn("skipped, it has neither a regex nor a path")
+			continue
+		case rule.Regex == "":
+			signature.Part = PartPath
+			signature.Regex = path
+		case path != "":
+			// matching the regex anywhere would report far more than the rule
+			warn("skipped, it matches both a path and contents, which a signature cannot express")
+			continue
+		}
+
+		for _, entropy := range rule.Entropies {
+			min, group, err := parseGitleaksEntropy(entropy)
+			if err != nil {
+				warn("ignored entropy range: %s", err)
+				continue
+			}
+			signature.Entropy = min
+			if group > 0 && signature.SecretGroup == 0 {
+				signature.SecretGroup = group
+			}
+			if entropy.Max != "" && entropy.Max != "8" && entropy.Max != "8.0" {
+				warn("dropped the maximum entropy %s", entropy.Max)
+			}
+		}
+
+		// keywords, entropy and secret groups only apply to contents
+		if signature.Part != PartContents {
+			if signature.Entropy > 0 || signature.SecretGroup > 0 {
+				warn("dropped the entropy and secret group, which do not apply to a path")
+			}
+			signature.Keywords, signature.Entropy, signature.SecretGroup = nil, 0, 0
+		}
+
+		allowlists := append([]gitleaksAllowlist{}, rule.Allowlists...)
+		if rule.Allowlist != nil {
+			allowlists = append(allowlists, *rule.Allowlist)
+		}
+		if config.Allowlist != nil {
+			allowlists = append(allowlists, *config.Allowlist)
+		}
+
+		for _, allowlist := range allowlists {
+			if strings.EqualFold(allowlist.Condition, "AND") {
+				warn("allowlist %q requires all of its conditions, which is not supported; it was skipped", allowlist.Description)
+				continue
+			}
+			if strings.EqualFold(allowlist.RegexTarget, "line") && len(allowlist.Regexes) > 0 {
+				warn("allowlist regexes targeting the whole line are applied to the secret instead")
+			}
+			if len(allowlist.Commits) > 0 {
+				warn("dropped %d allowlisted %s", len(allowlist.Commits), Pluralize(len(allowlist.Commits), "commit", "commits"))
+			}
+
+			signature.Allowlist.Regexes = append(signature.Allowlist.Regexes, allowlist.Regexes...)
+			signature.Allowlist.Paths = append(signature.Allowlist.Paths, allowlist.Paths...)
+			signature.Allowlist.Paths = append(signature.Allowlist.Paths, allowlist.Files...)
+			signature.Allowlist.Stopwords = append(signature.Allowlist.Stopwords, allowlist.Stopwords...)
+		}
+
+		if _, err := NewSignature(signature); err != nil {
+			warn("skipped, %s", err)
+			continue
+		}
+
+		signatures = append(signatures, signature)
+	}
+
+	return signatures, warnings, nil
+}
+
+func parseGitleaksEntropy(entropy gitleaksEntropy) (float64, int, error) {
+	var (
+		min   float64
+		group int
+	)
+
+	if _, err := fmt.Sscan(entropy.Min, &min); err != nil {
+		return 0, 0, fmt.Errorf("invalid minimum %q", entropy.Min)
+	}
+
+	if entropy.Group != "" {
+		if _, err := fmt.Sscan(entropy.Group, &group); err != nil {
+			return 0, 0, fmt.Errorf("invalid group %q", entropy.Group)
+		}
+	}
+
+	return min, group, nil
+}
+
+// ImportTrufflehog converts trufflehog rules: either a v3 custom detector
+// YAML file, where each named regex becomes a signature, or a v2 JSON file
+// mapping rule names to regexes.
+func ImportTrufflehog(data []byte) ([]ConfigSignature, []string, error) {
+	var rules map[string]string
+	if err := json.Unmarshal(data, &rules); err == nil {
+		return importTrufflehogRules(rules)
+	}
+
+	var config trufflehogConfig
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		signatures []ConfigSignature
+		warnings   []string
+	)
+
+	for _, detector := range config.Detectors {
+		warn := func(format string, args ...interface{}) {
+			warnings = append(warnings, fmt.Sprintf("%s: %s", detector.Name, fmt.Sprintf(format, args...)))
+		}
+
+		if len(detector.Regex) == 0 {
+			warn("skipped, it has no regex")
+			continue
+		}
+		if len(detector.Regex) > 1 {
+			warn("its %d regexes are imported as separate signatures", len(detector.Regex))
+		}
+		if len(detector.Verify) > 0 {
+			warn("dropped the verification webhook")
+		}
+		if len(detector.ExcludeRegexesCapture) > 0 {
+			warn("exclude_regexes_capture are applied to the whole match")
+		}
+
+		for _, regexName := range sortedKeys(detector.Regex) {
+			name := detector.Name
+			if len(detector.Regex) > 1 {
+				name = fmt.Sprintf("%s (%s)", detector.Name, regexName)
+			}
+
+			signature := ConfigSignature{
+				Name:     name,
+				Part:     PartContents,
+				Regex:    detector.Regex[regexName],
+				Keywords: detector.Keywords,
+				Entropy:  detector.Entropy,
+				Tags:     []string{"trufflehog"},
+				Allowlist: ConfigAllowlist{
+					Regexes:   append(append([]string{}, detector.ExcludeRegexesMatch...), detector.ExcludeRegexesCapture...),
+					Stopwords: detector.ExcludeWords,
+				},
+			}
+
+			// trufflehog reports the first capture group when there is one
+			if compiled, err := regexp.Compile(signature.Regex); err == nil && compiled.NumSubexp() > 0 {
+				signature.SecretGroup = 1
+			}
+
+			if _, err := NewSignature(signature); err != nil {
+				warn("skipped %s, %s", regexName, err)
+				continue
+			}
+
+			signatures = append(signatures, signature)
+		}
+	}
+
+	return signatures, warnings, nil
+}
+
+func importTrufflehogRules(rules map[string]string) ([]ConfigSignature, []string, error) {
+	var (
+		signatures []ConfigSignature
+		warnings   []string
+	)
+
+	for _, name := range sortedKeys(rules) {
+		signature := ConfigSignature{
+			Name:  name,
+			Part:  PartContents,
+			Regex: rules[name],
+			Tags:  []string{"trufflehog"},
+		}
+
+		if _, err := NewSignature(signature); err != nil {
+			warnings = append(warnings, fmt.Sprintf("%s: skipped, %s", name, err))
+			continue
+		}
+
+		signatures = append(signatures, signature)
+	}
+
+	return signatures, warnings, nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
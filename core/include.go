@@ -0,0 +1,189 @@
+package core
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SignaturePacksDir is loaded automatically from next to config.yaml, before
+// anything config.yaml includes itself.
+const SignaturePacksDir = "signatures.d"
+
+// configLoader merges a configuration file with everything it includes.
+//
+// Every file's includes are merged before the file's own settings, in the
+// order they are listed, so a file always overrides what it includes. Lists
+// are appended, other settings replace earlier values, and a signature with
+// the same name as an earlier one replaces it in place.
+type configLoader struct {
+	config  *Config
+	loading map[string]bool
+	fields  map[string]reflect.Value
+}
+
+func newConfigLoader(config *Config) *configLoader {
+	loader := &configLoader{
+		config:  config,
+		loading: make(map[string]bool),
+		fields:  make(map[string]reflect.Value),
+	}
+
+	value := reflect.ValueOf(config).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		name := strings.Split(value.Type().Field(i).Tag.Get("yaml"), ",")[0]
+		if name != "" && name != "-" {
+			loader.fields[name] = value.Field(i)
+		}
+	}
+
+	return loader
+}
+
+// resolveInclude expands an include entry, relative to dir, in to the files
+// it names: a file, every .yaml/.yml file in a directory, or a glob.
+func resolveInclude(dir string, include string) ([]string, error) {
+	if !filepath.IsAbs(include) {
+		include = filepath.Join(dir, include)
+	}
+
+	if strings.ContainsAny(include, "*?[") {
+		matches, err := filepath.Glob(include)
+		sort.Strings(matches)
+		return matches, err
+	}
+
+	info, err := os.Stat(include)
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		return []string{include}, nil
+	}
+
+	var files []string
+	for _, pattern := range []string{"*.yaml", "*.yml"} {
+		matches, _ := filepath.Glob(filepath.Join(include, pattern))
+		files = append(files, matches...)
+	}
+	sort.Strings(files)
+
+	return files, nil
+}
+
+func (l *configLoader) load(path string, includes []string) error {
+	absolute, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	if l.loading[absolute] {
+		return fmt.Errorf("%s: include cycle", path)
+	}
+	l.loading[absolute] = true
+	defer delete(l.loading, absolute)
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+
+	if len(root.Content) == 0 {
+		l.config.Files = append(l.config.Files, path)
+		return nil
+	}
+
+	document := root.Content[0]
+	if document.Kind != yaml.MappingNode {
+		return fmt.Errorf("%s:%d: expected a mapping at the top level", path, document.Line)
+	}
+
+	for i := 0; i+1 < len(document.Content); i += 2 {
+		if document.Content[i].Value == "include" {
+			var own []string
+			if err := document.Content[i+1].Decode(&own); err != nil {
+				return fmt.Errorf("%s: %s", path, err)
+			}
+			includes = append(includes, own...)
+		}
+	}
+
+	for _, include := range includes {
+		files, err := resolveInclude(filepath.Dir(path), include)
+		if err != nil {
+			return fmt.Errorf("%s: include %s: %s", path, include, err)
+		}
+
+		for _, file := range files {
+			if err := l.load(file, nil); err != nil {
+				return err
+			}
+		}
+	}
+
+	for i := 0; i+1 < len(document.Content); i += 2 {
+		key, value := document.Content[i], document.Content[i+1]
+		if err := l.merge(key.Value, value); err != nil {
+			return fmt.Errorf("%s:%d: %s: %s", path, value.Line, key.Value, err)
+		}
+	}
+
+	l.config.Files = append(l.config.Files, path)
+	return nil
+}
+
+func (l *configLoader) merge(key string, value *yaml.Node) error {
+	field, exists := l.fields[key]
+	if !exists || key == "include" {
+		return nil
+	}
+
+	if key == "signatures" {
+		var signatures []ConfigSignature
+		if err := value.Decode(&signatures); err != nil {
+			return err
+		}
+		l.mergeSignatures(signatures)
+		return nil
+	}
+
+	if field.Kind() == reflect.Slice {
+		items := reflect.New(field.Type())
+		if err := value.Decode(items.Interface()); err != nil {
+			return err
+		}
+		field.Set(reflect.AppendSlice(field, items.Elem()))
+		return nil
+	}
+
+	return value.Decode(field.Addr().Interface())
+}
+
+func (l *configLoader) mergeSignatures(signatures []ConfigSignature) {
+	for _, signature := range signatures {
+		replaced := false
+		for i, existing := range l.config.Signatures {
+			if existing.Name != "" && existing.Name == signature.Name {
+				l.config.Signatures[i] = signature
+				replaced = true
+				break
+			}
+		}
+
+		if !replaced {
+			l.config.Signatures = append(l.config.Signatures, signature)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// liveHistory is how many findings a newly connected dashboard is sent.
+	liveHistory = 1000
+
+	// liveBuffer is how many findings may be waiting for a slow dashboard
+	// before it is disconnected.
+	liveBuffer = 256
+)
+
+// LiveFinding is a finding as sent to the dashboard: the columns of its
+// signature's view, with the secret redacted, as shown in the UI.
+type LiveFinding struct {
+	Signature   string    `json:"signature"`
+	Relevance   string    `json:"relevance"`
+	Severity    string    `json:"severity"`
+	Source      string    `json:"source"`
+	Url         string    `json:"url"`
+	Fingerprint string    `json:"fingerprint"`
+	Time        time.Time `json:"time"`
+	Columns     []string  `json:"columns"`
+	Values      []string  `json:"values"`
+	Remediation string    `json:"remediation,omitempty"`
+	Tags        []string  `json:"tags,omitempty"`
+}
+
+// LiveDashboard serves a web page streaming findings as they are published,
+// over Server-Sent Events.
+type LiveDashboard struct {
+	sync.Mutex
+
+	session     *Session
+	history     []*LiveFinding
+	subscribers map[chan *LiveFinding]bool
+}
+
+// NewLiveDashboard returns a dashboard for the session's findings.
+func NewLiveDashboard(session *Session) *LiveDashboard {
+	return &LiveDashboard{
+		session:     session,
+		subscribers: make(map[chan *LiveFinding]bool),
+	}
+}
+
+// Serve serves the dashboard on listener until the session stops.
+func (d *LiveDashboard) Serve(listener net.Listener) error {
+	return serveUntilDone(d.session.Context, listener, d)
+}
+
+// Publish sends event to every connected dashboard. It is safe to call on a
+// nil dashboard.
+func (d *LiveDashboard) Publish(event *MatchEvent) {
+	if d == nil {
+		return
+	}
+
+	columns := d.session.GetView(event.Signature)
+	finding := &LiveFinding{
+		Signature:   event.Signature,
+		Relevance:   event.Relevance.String(),
+		Severity:    event.Severity,
+		Source:      event.Source.String(),
+		Url:         event.Url,
+		Fingerprint: d.session.Fingerprint(event),
+		Time:        time.Now(),
+		Columns:     columns,
+		Values:      make([]string, len(columns)),
+		Remediation: event.Remediation,
+		Tags:        event.Tags,
+	}
+	// the secret is redacted wherever it appears, not only in the Match
+	// column, e.g. in a validator's AdditionalInfo
+	redaction := d.session.CurrentConfig().Redaction
+	redacted := redaction.Redact(event.Match)
+	for i, column := range columns {
+		if value, exists := event.GetColumn(column, redaction); exists {
+			if event.Match != "" {
+				value = strings.ReplaceAll(value, event.Match, redacted)
+			}
+			finding.Values[i] = value
+		} else {
+			finding.Values[i] = "???"
+		}
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	d.history = append(d.history, finding)
+	if len(d.history) > liveHistory {
+		d.history = d.history[len(d.history)-liveHistory:]
+	}
+
+	for subscriber := range d.subscribers {
+		select {
+		case subscriber <- finding:
+		default:
+			// too slow to keep up, it reconnects and gets the history again
+			delete(d.subscribers, subscriber)
+			close(subscriber)
+		}
+	}
+}
+
+// ServeHTTP serves the dashboard page on / and the findings on /events. With
+// a live_token, both require it as a bearer token or the token parameter,
+// which the page passes on to /events.
+func (d *LiveDashboard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !queryAuthorized(r, d.session.CurrentConfig().LiveToken) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, liveDashboardPage)
+	case "/events":
+		d.stream(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (d *LiveDashboard) stream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	subscriber := make(chan *LiveFinding, liveBuffer)
+	d.Lock()
+	history := append([]*LiveFinding{}, d.history...)
+	d.subscribers[subscriber] = true
+	d.Unlock()
+
+	defer func() {
+		d.Lock()
+		if d.subscribers[subscriber] {
+			delete(d.subscribers, subscriber)
+			close(subscriber)
+		}
+		d.Unlock()
+	}()
+
+	for _, finding := range history {
+		writeEvent(w, finding)
+	}
+	flusher.Flush()
+
+	keepalive := time.NewTicker(30 * time.Second)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case finding, open := <-subscriber:
+			if !open {
+				return
+			}
+			writeEvent(w, finding)
+			flusher.Flush()
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		case <-d.session.Context.Done():
+			return
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, finding *LiveFinding) {
+	data, err := json.Marshal(finding)
+	if err != nil {
+		return
+	}
+
+	fmt.Fprintf(w, "event: finding\ndata: %s\n\n", data)
+}
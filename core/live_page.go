@@ -0,0 +1,181 @@
+package core
+
+// liveDashboardPage is the single page served by the --live dashboard. It
+// mirrors the UI: signatures on the left, the selected signature's findings
+// in its view's columns on the right, coloured by relevance, and h toggles
+// low relevance findings.
+const liveDashboardPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>AetherKey live</title>
+<style>
+  * { box-sizing: border-box; }
+  body { margin: 0; background: #101418; color: #e6e6e6; font: 13px/1.4 ui-monospace, SFMono-Regular, Menlo, Consolas, monospace; display: flex; flex-direction: column; height: 100vh; }
+  header { display: flex; align-items: center; gap: 18px; padding: 8px 14px; border-bottom: 1px solid #00bcbc; }
+  header h1 { margin: 0; font-size: 15px; color: #00ffff; letter-spacing: 1px; }
+  header label { cursor: pointer; user-select: none; }
+  #state { margin-left: auto; color: #888; }
+  #state.connected { color: #5fd75f; }
+  main { display: flex; flex: 1; min-height: 0; }
+  nav { width: 300px; overflow-y: auto; border-right: 1px solid #00bcbc; }
+  nav div { padding: 4px 12px; cursor: pointer; display: flex; justify-content: space-between; gap: 8px; }
+  nav div:hover { background: #1c2430; }
+  nav div.selected { background: #00bcbc; color: #101418; }
+  nav span.count { color: #888; }
+  nav div.selected span.count { color: #101418; }
+  section { flex: 1; overflow: auto; }
+  table { border-collapse: collapse; width: 100%; }
+  th { position: sticky; top: 0; background: #101418; text-align: left; padding: 6px 10px; border-bottom: 1px solid #333; }
+  td { padding: 4px 10px; border-bottom: 1px solid #1c2430; white-space: nowrap; max-width: 600px; overflow: hidden; text-overflow: ellipsis; }
+  tr.high td { color: #00ffff; }
+  tr.low td { color: #808080; }
+  td.critical { color: #ff0000 !important; }
+  td.high-severity { color: #ffa500 !important; }
+  td.medium-severity { color: #ffff00 !important; }
+  a { color: inherit; }
+  #empty { padding: 20px; color: #888; }
+</style>
+</head>
+<body>
+<header>
+  <h1>AETHERKEY</h1>
+  <span>Relevance:</span>
+  <label><input type="checkbox" data-relevance="high" checked> high</label>
+  <label><input type="checkbox" data-relevance="medium" checked> medium</label>
+  <label><input type="checkbox" data-relevance="low" checked> low</label>
+  <span id="total">0 findings</span>
+  <span id="state">connecting</span>
+</header>
+<main>
+  <nav id="signatures"></nav>
+  <section>
+    <table><thead id="head"></thead><tbody id="rows"></tbody></table>
+    <div id="empty">Waiting for findings…</div>
+  </section>
+</main>
+<script>
+(function () {
+  var ALL = "All signatures";
+  var findings = [];
+  var seen = {};
+  var counts = {};
+  var selected = ALL;
+  var shown = { high: true, medium: true, low: true };
+
+  function el(tag, text, className) {
+    var node = document.createElement(tag);
+    if (text !== undefined) node.textContent = text;
+    if (className) node.className = className;
+    return node;
+  }
+
+  function cell(column, value, finding) {
+    var td = el("td");
+    if (/^https?:\/\//.test(value)) {
+      var link = el("a", value);
+      link.href = value;
+      link.target = "_blank";
+      link.rel = "noopener noreferrer";
+      td.appendChild(link);
+    } else {
+      td.textContent = value;
+    }
+    td.title = value;
+    if (column === "Severity") {
+      td.className = { critical: "critical", high: "high-severity", medium: "medium-severity" }[(finding.severity || "medium").toLowerCase()] || "";
+    }
+    return td;
+  }
+
+  function visible(finding) {
+    return shown[finding.relevance] !== false && (selected === ALL || finding.signature === selected);
+  }
+
+  function columnsFor(finding) {
+    if (selected !== ALL) return { names: finding.columns, values: finding.values };
+    return {
+      names: ["Time", "Signature"].concat(finding.columns),
+      values: [new Date(finding.time).toLocaleTimeString(), finding.signature].concat(finding.values)
+    };
+  }
+
+  function header(names) {
+    var head = document.getElementById("head");
+    head.textContent = "";
+    var tr = el("tr");
+    names.forEach(function (name) { tr.appendChild(el("th", name)); });
+    head.appendChild(tr);
+  }
+
+  function row(finding) {
+    var columns = columnsFor(finding);
+    var tr = el("tr", undefined, finding.relevance);
+    columns.names.forEach(function (name, i) { tr.appendChild(cell(name, columns.values[i], finding)); });
+    return tr;
+  }
+
+  function redraw() {
+    var rows = document.getElementById("rows");
+    rows.textContent = "";
+    var list = findings.filter(visible);
+    if (list.length > 0) header(columnsFor(list[0]).names);
+    else document.getElementById("head").textContent = "";
+    for (var i = list.length - 1; i >= 0; i--) rows.appendChild(row(list[i]));
+    document.getElementById("empty").style.display = list.length ? "none" : "block";
+  }
+
+  function drawSignatures() {
+    var nav = document.getElementById("signatures");
+    nav.textContent = "";
+    [ALL].concat(Object.keys(counts).sort()).forEach(function (name) {
+      var item = el("div", undefined, name === selected ? "selected" : "");
+      item.appendChild(el("span", name));
+      item.appendChild(el("span", String(name === ALL ? findings.length : counts[name]), "count"));
+      item.onclick = function () { selected = name; drawSignatures(); redraw(); };
+      nav.appendChild(item);
+    });
+    document.getElementById("total").textContent = findings.length + (findings.length === 1 ? " finding" : " findings");
+  }
+
+  function add(finding) {
+    if (seen[finding.fingerprint]) return;
+    seen[finding.fingerprint] = true;
+    findings.push(finding);
+    counts[finding.signature] = (counts[finding.signature] || 0) + 1;
+    drawSignatures();
+
+    if (!visible(finding)) return;
+    var rows = document.getElementById("rows");
+    if (!rows.firstChild) header(columnsFor(finding).names);
+    rows.insertBefore(row(finding), rows.firstChild);
+    document.getElementById("empty").style.display = "none";
+  }
+
+  function toggle(relevance, on) {
+    shown[relevance] = on;
+    document.querySelector("input[data-relevance=" + relevance + "]").checked = on;
+    redraw();
+  }
+
+  document.querySelectorAll("input[data-relevance]").forEach(function (input) {
+    input.onchange = function () { toggle(input.getAttribute("data-relevance"), input.checked); };
+  });
+
+  document.addEventListener("keydown", function (event) {
+    if (event.key === "h" && !event.ctrlKey && !event.metaKey) toggle("low", !shown.low);
+  });
+
+  var state = document.getElementById("state");
+  var source = new EventSource("events" + location.search);
+  source.addEventListener("finding", function (event) { add(JSON.parse(event.data)); });
+  source.onopen = function () { state.textContent = "live"; state.className = "connected"; };
+  source.onerror = function () { state.textContent = "reconnecting"; state.className = ""; };
+
+  drawSignatures();
+})();
+</script>
+</body>
+</html>
+`
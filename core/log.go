@@ -55,7 +55,7 @@ func (l *Logger) Log(level int, format string, args ...interface{}) {
 		return
 	}
 
-	if GetUI() == nil {
+	if GetUI().LogWindow == nil {
 		if c, ok := LogColors[level]; ok {
 			c.Printf("\r"+format+"\n", args...)
 		} else {
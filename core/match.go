@@ -1,9 +1,11 @@
 package core
 
 import (
+	"bytes"
 	"io/ioutil"
-	"os"
+	"net/http"
 	"path/filepath"
+	"strconv"
 	"strings"
 )
 
@@ -15,15 +17,98 @@ const (
 	RelevanceLow
 )
 
+func (r Relevance) String() string {
+	switch r {
+	case RelevanceHigh:
+		return "high"
+	case RelevanceMedium:
+		return "medium"
+	case RelevanceLow:
+		return "low"
+	}
+
+	return "unknown"
+}
+
+// binarySniffLength is how much of a file is inspected when deciding whether
+// it is binary, mirroring what git itself looks at.
+const binarySniffLength = 8000
+
+var textualMimeTypes = []string{"application/json", "application/xml", "application/javascript"}
+
 type MatchEvent struct {
+	SignatureMetadata
+
 	Url            string
+	Permalink      string
 	Match          string
 	Signature      string
 	File           string
+	Line           int
+	Column         int
 	Stars          int
 	Source         GitResourceType
 	AdditionalInfo map[string]string
 	Relevance      Relevance
+	Decoding       string
+	Part           string
+
+	// LocalPath is the file the match was found in, on this machine. It is
+	// only set while the clone or fetched file is kept, to show its context.
+	LocalPath string `json:"-"`
+}
+
+// GetColumn returns the value shown for a view column, looking first at the
+// event's own fields and then at the validator's AdditionalInfo. The match is
+// shown, and the fingerprint keyed, according to redaction.
+func (e *MatchEvent) GetColumn(column string, redaction Redaction) (string, bool) {
+	switch column {
+	case "Repository":
+		return e.Url, true
+	case "URL":
+		if e.Permalink != "" {
+			return e.Permalink, true
+		}
+		return e.Url, true
+	case "File":
+		return e.File, true
+	case "Line":
+		if e.Line > 0 {
+			return strconv.Itoa(e.Line), true
+		}
+		return "", true
+	case "Column":
+		if e.Column > 0 {
+			return strconv.Itoa(e.Column), true
+		}
+		return "", true
+	case "Match":
+		return redaction.Redact(e.Match), true
+	case "Decoding":
+		return e.Decoding, true
+	case "Fingerprint":
+		return e.Fingerprint(redaction.Salt), true
+	case "Signature":
+		return e.Signature, true
+	case "Severity":
+		return e.Severity, true
+	case "Tags":
+		return strings.Join(e.Tags, ", "), true
+	case "Confidence":
+		return e.Confidence, true
+	case "Description":
+		return e.Description, true
+	case "Remediation":
+		return e.Remediation, true
+	}
+
+	value, exists := e.AdditionalInfo[column]
+	return value, exists
+}
+
+// HasAllowMarker reports whether line carries the inline AllowMarker.
+func HasAllowMarker(line []byte) bool {
+	return bytes.Contains(bytes.ToLower(line), []byte(AllowMarker))
 }
 
 type MatchFile struct {
@@ -47,50 +132,29 @@ func NewMatchFile(path string) MatchFile {
 	}
 }
 
-func IsSkippableFile(path string) bool {
-	extension := strings.ToLower(filepath.Ext(path))
-
-	for _, skippableExt := range session.Config.BlacklistedExtensions {
-		if extension == skippableExt {
-			return true
-		}
+// IsBinary sniffs the start of contents and reports whether it looks like a
+// binary file: either it contains a NUL byte or its detected MIME type is not
+// a textual one.
+func IsBinary(contents []byte) bool {
+	sniff := contents
+	if len(sniff) > binarySniffLength {
+		sniff = sniff[:binarySniffLength]
 	}
 
-	for _, skippablePathIndicator := range session.Config.BlacklistedPaths {
-		skippablePathIndicator = strings.Replace(skippablePathIndicator, "{sep}", string(os.PathSeparator), -1)
-		if strings.Contains(path, skippablePathIndicator) {
-			return true
-		}
+	if bytes.IndexByte(sniff, 0) != -1 {
+		return true
 	}
 
-	return false
-}
-
-func (match MatchFile) CanCheckEntropy() bool {
-	if match.Filename == "id_rsa" {
+	mimeType := http.DetectContentType(sniff)
+	if strings.HasPrefix(mimeType, "text/") {
 		return false
 	}
 
-	for _, skippableExt := range session.Config.BlacklistedEntropyExtensions {
-		if match.Extension == skippableExt {
+	for _, textualType := range textualMimeTypes {
+		if strings.HasPrefix(mimeType, textualType) {
 			return false
 		}
 	}
 
 	return true
 }
-
-func GetMatchingFiles(dir string) []MatchFile {
-	fileList := make([]MatchFile, 0)
-	maxFileSize := *session.Options.MaximumFileSize * 1024
-
-	filepath.Walk(dir, func(path string, f os.FileInfo, err error) error {
-		if err != nil || f.IsDir() || uint(f.Size()) > maxFileSize || IsSkippableFile(path) {
-			return nil
-		}
-		fileList = append(fileList, NewMatchFile(path))
-		return nil
-	})
-
-	return fileList
-}
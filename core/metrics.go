@@ -0,0 +1,148 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// metricLabelEscaper escapes label values for the Prometheus text format.
+var metricLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// metricsWriter writes metrics in the Prometheus text exposition format.
+type metricsWriter struct {
+	w io.Writer
+}
+
+// family writes the HELP and TYPE lines that precede a metric's samples.
+func (m metricsWriter) family(name string, kind string, help string) {
+	fmt.Fprintf(m.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// sample writes one value of name, with labels given as name, value pairs.
+func (m metricsWriter) sample(name string, value float64, labels ...string) {
+	fmt.Fprint(m.w, name)
+	if len(labels) > 0 {
+		pairs := make([]string, 0, len(labels)/2)
+		for i := 0; i+1 < len(labels); i += 2 {
+			pairs = append(pairs, fmt.Sprintf(`%s="%s"`, labels[i], metricLabelEscaper.Replace(labels[i+1])))
+		}
+		fmt.Fprintf(m.w, "{%s}", strings.Join(pairs, ","))
+	}
+	fmt.Fprintf(m.w, " %s\n", strconv.FormatFloat(value, 'f', -1, 64))
+}
+
+// single writes a metric family with one unlabelled sample.
+func (m metricsWriter) single(name string, kind string, help string, value float64) {
+	m.family(name, kind, help)
+	m.sample(name, value)
+}
+
+// WriteMetrics writes the session's counters, queue depths and GitHub rate
+// limits in the Prometheus text format.
+func (s *Session) WriteMetrics(w io.Writer) {
+	m := metricsWriter{w}
+	stats := s.Stats
+
+	m.single("aetherkey_github_events_polled_total", "counter", "GitHub events fetched from the events API.", float64(stats.GetEventsPolled()))
+	m.single("aetherkey_gists_polled_total", "counter", "Gists fetched from the gists API.", float64(stats.GetGistsPolled()))
+
+	m.family("aetherkey_repositories_cloned_total", "counter", "Repository and gist clones, by outcome.")
+	m.sample("aetherkey_repositories_cloned_total", float64(stats.GetReposCloned()), "outcome", "success")
+	m.sample("aetherkey_repositories_cloned_total", float64(stats.GetReposFailed()), "outcome", "failure")
+
+	m.single("aetherkey_files_scanned_total", "counter", "Files that signatures were run on.", float64(stats.GetFilesScanned()))
+	m.single("aetherkey_bytes_scanned_total", "counter", "Bytes of file contents that signatures were run on.", float64(stats.GetBytesScanned()))
+
+	m.family("aetherkey_files_skipped_total", "counter", "Files not scanned, by reason.")
+	m.sample("aetherkey_files_skipped_total", float64(stats.GetBinaryFilesSkipped()), "reason", "binary")
+	m.sample("aetherkey_files_skipped_total", float64(stats.GetFilteredFilesSkipped()), "reason", "filtered")
+
+	m.single("aetherkey_findings_baselined_total", "counter", "Findings suppressed by the baseline.", float64(stats.GetBaselinedFindings()))
+
+	m.family("aetherkey_findings_total", "counter", "Findings reported, by signature and relevance.")
+	findings := stats.GetFindingsByLabels()
+	findingLabels := make([]FindingLabels, 0, len(findings))
+	for labels := range findings {
+		findingLabels = append(findingLabels, labels)
+	}
+	sort.Slice(findingLabels, func(i, j int) bool {
+		if findingLabels[i].Signature != findingLabels[j].Signature {
+			return findingLabels[i].Signature < findingLabels[j].Signature
+		}
+		return findingLabels[i].Relevance < findingLabels[j].Relevance
+	})
+	for _, labels := range findingLabels {
+		m.sample("aetherkey_findings_total", float64(findings[labels]), "signature", labels.Signature, "relevance", labels.Relevance)
+	}
+
+	m.family("aetherkey_validations_total", "counter", "Validator calls, by signature and outcome.")
+	validations := stats.GetValidations()
+	validationLabels := make([]ValidationLabels, 0, len(validations))
+	for labels := range validations {
+		validationLabels = append(validationLabels, labels)
+	}
+	sort.Slice(validationLabels, func(i, j int) bool {
+		if validationLabels[i].Signature != validationLabels[j].Signature {
+			return validationLabels[i].Signature < validationLabels[j].Signature
+		}
+		return !validationLabels[i].Valid && validationLabels[j].Valid
+	})
+	for _, labels := range validationLabels {
+		outcome := "invalid"
+		if labels.Valid {
+			outcome = "valid"
+		}
+		m.sample("aetherkey_validations_total", float64(validations[labels]), "signature", labels.Signature, "outcome", outcome)
+	}
+
+	rateLimits := stats.GetRateLimits()
+	tokens := make([]string, 0, len(rateLimits))
+	for token := range rateLimits {
+		tokens = append(tokens, token)
+	}
+	sort.Strings(tokens)
+
+	m.family("aetherkey_github_rate_limit_remaining", "gauge", "GitHub API calls left for a token, as last reported by GitHub.")
+	for _, token := range tokens {
+		m.sample("aetherkey_github_rate_limit_remaining", float64(rateLimits[token].Remaining), "token", token)
+	}
+	m.family("aetherkey_github_rate_limit", "gauge", "GitHub API calls allowed per hour for a token.")
+	for _, token := range tokens {
+		m.sample("aetherkey_github_rate_limit", float64(rateLimits[token].Limit), "token", token)
+	}
+	m.family("aetherkey_github_rate_limit_reset_timestamp_seconds", "gauge", "When a token's GitHub rate limit resets, in seconds since the epoch.")
+	for _, token := range tokens {
+		m.sample("aetherkey_github_rate_limit_reset_timestamp_seconds", float64(rateLimits[token].Reset.Unix()), "token", token)
+	}
+
+	m.family("aetherkey_queue_depth", "gauge", "Items waiting in each work queue.")
+	for _, queue := range s.queues() {
+		if *queue.queue != nil {
+			m.sample("aetherkey_queue_depth", float64((*queue.queue).Len()), "queue", queue.name)
+		}
+	}
+
+	m.family("aetherkey_github_clients", "gauge", "GitHub clients available or waiting for their rate limit to reset.")
+	m.sample("aetherkey_github_clients", float64(len(s.Clients)), "state", "available")
+	m.sample("aetherkey_github_clients", float64(len(s.ExhaustedClients)), "state", "exhausted")
+}
+
+// ServeMetrics serves the metrics on /metrics until the session stops.
+func (s *Session) ServeMetrics(listener net.Listener) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		var buffer bytes.Buffer
+		s.WriteMetrics(&buffer)
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write(buffer.Bytes())
+	})
+
+	return serveUntilDone(s.Context, listener, mux)
+}
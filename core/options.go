@@ -2,8 +2,11 @@ package core
 
 import (
 	"flag"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 )
 
 type Options struct {
@@ -13,19 +16,58 @@ type Options struct {
 	MaximumRepositorySize  *uint
 	MaximumFileSize        *uint
 	CloneRepositoryTimeout *uint
+	ScanArchives           *bool
+	ArchiveMaxDepth        *uint
+	ArchiveMaxEntries      *uint
+	ArchiveMaxSize         *uint
 	EntropyThreshold       *float64
+	DecodeDepth            *uint
 	MinimumStars           *uint
 	PathChecks             *bool
 	ProcessGists           *bool
 	TempDirectory          *string
+	StateDirectory         *string
 	CsvPath                *string
 	SearchQuery            *string
 	Local                  *string
 	Live                   *string
+	Metrics                *string
+	MinimumSeverity        *string
+	Tags                   *string
+	Baseline               *string
+	UpdateBaseline         *bool
 	ConfigPath             *string
+	Coordinator            *string
+	Worker                 *string
+	Serve                  *string
+	ServeHosts             *string
+	ServeJobs              *uint
+	ServeRoot              *string
 }
 
+var (
+	options     *Options
+	optionsSync sync.Once
+)
+
+// ParseOptions defines and parses the command line flags. It is safe to call
+// more than once; flags are only parsed the first time. An unknown
+// --minimum-severity is an error, rather than silently ranked as medium.
 func ParseOptions() (*Options, error) {
+	optionsSync.Do(func() {
+		options = parseOptions()
+	})
+
+	if severity := *options.MinimumSeverity; severity != "" {
+		if _, exists := severityRanks[strings.ToLower(severity)]; !exists {
+			return options, fmt.Errorf("Unknown --minimum-severity %q. Use one of %s.", severity, strings.Join(sortedSeverities(), ", "))
+		}
+	}
+
+	return options, nil
+}
+
+func parseOptions() *Options {
 	options := &Options{
 		Threads:                flag.Int("threads", 0, "Number of concurrent threads (default number of logical CPUs)"),
 		Silent:                 flag.Bool("silent", false, "Suppress all output except for errors"),
@@ -33,19 +75,36 @@ func ParseOptions() (*Options, error) {
 		MaximumRepositorySize:  flag.Uint("maximum-repository-size", 5120, "Maximum repository size to process in KB"),
 		MaximumFileSize:        flag.Uint("maximum-file-size", 256, "Maximum file size to process in KB"),
 		CloneRepositoryTimeout: flag.Uint("clone-repository-timeout", 10, "Maximum time it should take to clone a repository in seconds. Increase this if you have a slower connection"),
+		ScanArchives:           flag.Bool("scan-archives", true, "Look inside zip, jar, whl, nupkg, tar and tar.gz archives. Set to false to disable"),
+		ArchiveMaxDepth:        flag.Uint("archive-max-depth", 3, "Maximum depth of nested archives to expand"),
+		ArchiveMaxEntries:      flag.Uint("archive-max-entries", 1000, "Maximum number of entries to read from a single archive"),
+		ArchiveMaxSize:         flag.Uint("archive-max-size", 51200, "Maximum total expanded size of a single archive in KB"),
 		EntropyThreshold:       flag.Float64("entropy-threshold", 5.0, "Set to 0 to disable entropy checks"),
+		DecodeDepth:            flag.Uint("decode-depth", 2, "Layers of base64/hex encoding to unwrap before matching signatures. Set to 0 to disable decoding"),
 		MinimumStars:           flag.Uint("minimum-stars", 0, "Only process repositories with this many stars. Default 0 will ignore star count"),
 		PathChecks:             flag.Bool("path-checks", true, "Set to false to disable checking of filepaths, i.e. just match regex patterns of file contents"),
 		ProcessGists:           flag.Bool("process-gists", true, "Will watch and process Gists. Set to false to disable."),
 		TempDirectory:          flag.String("temp-directory", filepath.Join(os.TempDir(), Name), "Directory to process and store repositories/matches"),
+		StateDirectory:         flag.String("state-directory", "", "Directory for the work queues and the record of events already seen, so a restart resumes where it stopped (default a state directory in the user cache directory)"),
 		CsvPath:                flag.String("csv-path", "", "CSV file path to log found secrets to. Leave blank to disable"),
 		SearchQuery:            flag.String("search-query", "", "Specify a search string to ignore signatures and filter on files containing this string (regex compatible)"),
 		Local:                  flag.String("local", "", "Specify local directory (absolute path) which to scan. Scans only given directory recursively. No need to have GitHub tokens with local run."),
-		Live:                   flag.String("live", "", "Your shhgit live endpoint"),
+		Live:                   flag.String("live", "", "Listen address, e.g. 127.0.0.1:8081, for a web dashboard streaming findings as they are found"),
+		Metrics:                flag.String("metrics", "", "Listen address, e.g. 127.0.0.1:9100, to serve Prometheus metrics on /metrics"),
+		MinimumSeverity:        flag.String("minimum-severity", "", "Only report findings of at least this severity: info, low, medium, high or critical"),
+		Tags:                   flag.String("tags", "", "Only report findings from signatures with any of these comma separated tags, e.g. cloud,payment"),
+		Baseline:               flag.String("baseline", "", "Baseline file of known finding fingerprints to suppress. With --local, only findings not in the baseline fail the run"),
+		UpdateBaseline:         flag.Bool("update-baseline", false, "Write every finding to the --baseline file instead of reporting it"),
 		ConfigPath:             flag.String("config-path", "", "Searches for config.yaml from given directory. If not set, tries to find if from shhgit binary's and current directory"),
+		Coordinator:            flag.String("coordinator", "", "Listen address, e.g. :8080, to hand queued work out to --worker processes instead of scanning it here"),
+		Serve:                  flag.String("serve", "", "Listen address, e.g. 127.0.0.1:8080, to run scans submitted over a REST API instead of watching GitHub"),
+		ServeHosts:             flag.String("serve-hosts", "github.com,gist.github.com", "Comma separated hosts that repositories submitted to --serve may be cloned from. Leave blank to refuse repository scans"),
+		ServeJobs:              flag.Uint("serve-jobs", 4, "Maximum number of --serve scans to run at once; more are queued"),
+		ServeRoot:              flag.String("serve-root", "", "Directory that local paths submitted to --serve must be inside. Leave blank to only allow repository and content scans"),
+		Worker:                 flag.String("worker", "", "URL of a --coordinator to take work from. Runs without the UI and reports findings back to the coordinator"),
 	}
 
 	flag.Parse()
 
-	return options, nil
+	return options
 }
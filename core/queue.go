@@ -0,0 +1,345 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// compactThreshold is how many bytes of consumed items a queue file may
+// hold before the remaining items are copied to a fresh file.
+const compactThreshold = 8 * 1024 * 1024
+
+// Done saves the cursor after cursorSaveEvery acks or cursorSaveDelay,
+// whichever comes first, rather than on every ack. A crash repeats at most
+// the work acked since, and Close always saves it.
+const (
+	cursorSaveEvery = 64
+	cursorSaveDelay = 5 * time.Second
+)
+
+var ErrQueueFull = errors.New("queue is full")
+
+// Ticket identifies an item handed out by Queue.Pop until it is marked Done.
+type Ticket int64
+
+// Queue is a durable FIFO of JSON values, stored one per line in an
+// append-only file. The read position is persisted in a cursor file next to
+// it and only moves past an item once it and every item before it are marked
+// Done. After a restart, everything from the oldest item that was still being
+// worked on is handed out again, so work may repeat but is never lost.
+//
+// Offsets are logical: they keep growing when the file is compacted, with
+// base being the logical offset of the first byte in the file.
+type Queue struct {
+	sync.Mutex
+
+	path      string
+	maxLength int
+	writer    *os.File
+	reader    *os.File
+	buffered  *bufio.Reader
+	base      int64
+	read      int64
+	size      int64
+	length    int
+	inflight  map[Ticket]bool
+	notify    chan struct{}
+	unsaved   int
+	saved     time.Time
+}
+
+// OpenQueue opens or creates the queue stored at path. Push fails with
+// ErrQueueFull once maxLength items are waiting.
+func OpenQueue(path string, maxLength int) (*Queue, error) {
+	q := &Queue{
+		path:      path,
+		maxLength: maxLength,
+		inflight:  make(map[Ticket]bool),
+		notify:    make(chan struct{}, 1),
+		saved:     time.Now(),
+	}
+
+	if err := q.open(); err != nil {
+		return nil, err
+	}
+
+	if data, err := ioutil.ReadFile(q.cursorPath()); err == nil {
+		if cursor, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64); err == nil && cursor >= 0 && cursor <= q.size {
+			q.read = cursor
+		}
+	}
+
+	if _, err := q.reader.Seek(q.read, io.SeekStart); err != nil {
+		q.Close()
+		return nil, err
+	}
+	q.buffered = bufio.NewReader(q.reader)
+
+	// count what is left to read
+	counter := bufio.NewReader(io.NewSectionReader(q.reader, q.read, q.size-q.read))
+	for {
+		line, err := counter.ReadBytes('\n')
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			q.length++
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	return q, nil
+}
+
+func (q *Queue) open() error {
+	writer, err := os.OpenFile(q.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	reader, err := os.Open(q.path)
+	if err != nil {
+		writer.Close()
+		return err
+	}
+
+	info, err := writer.Stat()
+	if err != nil {
+		writer.Close()
+		reader.Close()
+		return err
+	}
+
+	q.writer, q.reader = writer, reader
+	q.size = q.base + info.Size()
+
+	return nil
+}
+
+func (q *Queue) cursorPath() string {
+	return q.path + ".cursor"
+}
+
+// Push appends value to the queue.
+func (q *Queue) Push(value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	q.Lock()
+	defer q.Unlock()
+
+	if q.maxLength > 0 && q.length >= q.maxLength {
+		return ErrQueueFull
+	}
+
+	n, err := q.writer.Write(data)
+	q.size += int64(n)
+	if err != nil {
+		return err
+	}
+
+	q.length++
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// Pop waits for the next item, decodes it in to value and returns its
+// ticket, or returns the context's error once ctx is done.
+func (q *Queue) Pop(ctx context.Context, value interface{}) (Ticket, error) {
+	for {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+
+		item, ticket, ok, err := q.TryPop()
+		if err != nil {
+			return 0, err
+		}
+
+		if ok {
+			if err := json.Unmarshal(item, value); err != nil {
+				q.Done(ticket)
+				continue
+			}
+
+			return ticket, nil
+		}
+
+		select {
+		case <-q.notify:
+		case <-ctx.Done():
+		}
+	}
+}
+
+// TryPop is like Pop but returns ok false at once when the queue is empty.
+// The item is left undecoded so it can be handed on as is.
+func (q *Queue) TryPop() (item json.RawMessage, ticket Ticket, ok bool, err error) {
+	q.Lock()
+	defer q.Unlock()
+
+	if q.length == 0 {
+		return nil, 0, false, nil
+	}
+
+	line, err := q.buffered.ReadBytes('\n')
+	if err != nil {
+		return nil, 0, false, fmt.Errorf("reading %s: %s", q.path, err)
+	}
+
+	ticket = Ticket(q.read)
+	q.read += int64(len(line))
+	q.length--
+	q.inflight[ticket] = true
+
+	return json.RawMessage(line[:len(line)-1]), ticket, true, nil
+}
+
+// Done marks the item with ticket as handled so it is not handed out again
+// after a restart.
+func (q *Queue) Done(ticket Ticket) {
+	q.Lock()
+	defer q.Unlock()
+
+	delete(q.inflight, ticket)
+
+	q.unsaved++
+	if q.unsaved < cursorSaveEvery && time.Since(q.saved) < cursorSaveDelay {
+		return
+	}
+
+	if err := q.saveCursor(); err != nil {
+		return
+	}
+
+	cursor := q.cursor()
+	switch {
+	case q.length == 0 && len(q.inflight) == 0 && q.size > q.base:
+		q.truncate()
+	case cursor-q.base > compactThreshold && cursor-q.base > (q.size-q.base)/2:
+		q.compact(cursor)
+	}
+}
+
+// Len returns the number of items waiting to be popped.
+func (q *Queue) Len() int {
+	q.Lock()
+	defer q.Unlock()
+
+	return q.length
+}
+
+// Close persists the cursor and closes the queue's files. Items popped but
+// not marked Done are handed out again when the queue is next opened.
+func (q *Queue) Close() error {
+	q.Lock()
+	defer q.Unlock()
+
+	err := q.saveCursor()
+	q.writer.Close()
+	q.reader.Close()
+
+	return err
+}
+
+// cursor is the oldest offset that still has to be handed out after a
+// restart: the oldest item in flight, or the next one to read.
+func (q *Queue) cursor() int64 {
+	cursor := q.read
+	for ticket := range q.inflight {
+		if int64(ticket) < cursor {
+			cursor = int64(ticket)
+		}
+	}
+
+	return cursor
+}
+
+func (q *Queue) saveCursor() error {
+	if err := writeFileAtomic(q.cursorPath(), []byte(strconv.FormatInt(q.cursor()-q.base, 10))); err != nil {
+		return err
+	}
+
+	q.unsaved = 0
+	q.saved = time.Now()
+	return nil
+}
+
+// truncate empties the file once everything in it has been handled.
+func (q *Queue) truncate() {
+	if err := q.writer.Truncate(0); err != nil {
+		return
+	}
+
+	q.reader.Seek(0, io.SeekStart)
+	q.buffered.Reset(q.reader)
+	q.base = q.size
+	q.read = q.size
+	q.saveCursor()
+}
+
+// compact copies everything from cursor onwards in to a new file, so the
+// queue does not grow without bound while it is never completely empty.
+func (q *Queue) compact(cursor int64) {
+	source, err := os.Open(q.path)
+	if err != nil {
+		return
+	}
+	defer source.Close()
+
+	temp := q.path + ".compact"
+	target, err := os.OpenFile(temp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+
+	_, err = io.Copy(target, io.NewSectionReader(source, cursor-q.base, q.size-cursor))
+	target.Close()
+	if err != nil {
+		os.Remove(temp)
+		return
+	}
+
+	q.writer.Close()
+	q.reader.Close()
+	if err := os.Rename(temp, q.path); err != nil {
+		os.Remove(temp)
+	} else {
+		q.base = cursor
+	}
+
+	if err := q.open(); err != nil {
+		return
+	}
+
+	q.reader.Seek(q.read-q.base, io.SeekStart)
+	q.buffered = bufio.NewReader(q.reader)
+	q.saveCursor()
+}
+
+// writeFileAtomic replaces path with data so that a crash never leaves it
+// half written.
+func writeFileAtomic(path string, data []byte) error {
+	temp := path + ".tmp"
+	if err := ioutil.WriteFile(temp, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(temp, path)
+}
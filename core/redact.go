@@ -0,0 +1,117 @@
+package core
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const (
+	RedactNone = "none"
+	RedactMask = "mask"
+	RedactHash = "hash"
+)
+
+// Redaction decides how secrets are shown wherever they leave memory: the
+// CSVs, the log, the UI and validator output. Raw values are only ever kept
+// in the in-memory MatchEvent so validators can use them.
+type Redaction struct {
+	Mode   string `yaml:"mode"`
+	Prefix int    `yaml:"prefix"`
+	Suffix int    `yaml:"suffix"`
+	Salt   string `yaml:"salt,omitempty"`
+}
+
+// Redact applies the policy to secret. In mask mode all but Prefix and Suffix
+// characters are replaced with asterisks; in hash mode the masked preview is
+// followed by a salted hash of the full value, so the same secret can still be
+// recognised across findings without storing it.
+func (r Redaction) Redact(secret string) string {
+	switch r.Mode {
+	case RedactNone:
+		return secret
+	case RedactHash:
+		return fmt.Sprintf("%s %s", r.mask(secret), SecretHash(r.Salt, secret))
+	default:
+		return r.mask(secret)
+	}
+}
+
+// SecretHash is the salted hash of secret shown by the hash mode. Allowlists
+// list it to allow a secret without storing the secret itself.
+func SecretHash(salt string, secret string) string {
+	mac := hmac.New(sha256.New, []byte(salt))
+	mac.Write([]byte(secret))
+	return "sha256:" + hex.EncodeToString(mac.Sum(nil))[:32]
+}
+
+func (r Redaction) mask(secret string) string {
+	runes := []rune(secret)
+	prefix, suffix := r.Prefix, r.Suffix
+
+	// never reveal more than half of a short secret
+	for prefix+suffix > len(runes)/2 && (prefix > 0 || suffix > 0) {
+		if prefix >= suffix {
+			prefix--
+		} else {
+			suffix--
+		}
+	}
+
+	masked := len(runes) - prefix - suffix
+	if masked > 16 {
+		masked = 16
+	}
+
+	return string(runes[:prefix]) + strings.Repeat("*", masked) + string(runes[len(runes)-suffix:])
+}
+
+// Redact applies the session's redaction policy to secret.
+func (s *Session) Redact(secret string) string {
+	return s.CurrentConfig().Redaction.Redact(secret)
+}
+
+// InitRedaction validates the redaction mode and, without a configured salt,
+// loads or creates a random salt kept in the cache directory so hashes and
+// fingerprints stay comparable between runs. The salt is needed in every
+// mode as it also keys finding fingerprints.
+func (s *Session) InitRedaction() {
+	if err := s.prepareRedaction(&s.Config.Redaction); err != nil {
+		s.Log.Fatal("%s", err)
+	}
+}
+
+func (s *Session) prepareRedaction(redaction *Redaction) error {
+	switch redaction.Mode {
+	case RedactNone, RedactMask, RedactHash:
+	default:
+		return fmt.Errorf("Unknown redaction mode %q. Use one of none, mask or hash.", redaction.Mode)
+	}
+
+	if redaction.Salt != "" {
+		return nil
+	}
+
+	saltPath := fmt.Sprintf("%s%csalt", s.getCsvDir(), os.PathSeparator)
+	if salt, err := ioutil.ReadFile(saltPath); err == nil && len(salt) > 0 {
+		redaction.Salt = strings.TrimSpace(string(salt))
+		return nil
+	}
+
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return fmt.Errorf("Could not generate redaction salt: %s", err)
+	}
+
+	redaction.Salt = hex.EncodeToString(salt)
+	if err := ioutil.WriteFile(saltPath, []byte(redaction.Salt), 0600); err != nil {
+		s.Log.Warn("Could not store redaction salt, hashes will differ between runs: %s", err)
+	}
+
+	return nil
+}
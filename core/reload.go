@@ -0,0 +1,192 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// configPollInterval is how often the configuration files are re-read to
+// look for changes.
+const configPollInterval = 5 * time.Second
+
+// CurrentConfig returns the configuration in use. It may be swapped by a
+// reload at any time, so callers should fetch it once per unit of work.
+func (s *Session) CurrentConfig() *Config {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.Config
+}
+
+// CurrentSignatures returns the compiled signatures in use.
+func (s *Session) CurrentSignatures() []Signature {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.Signatures
+}
+
+// WatchConfig reloads the configuration whenever one of its files changes or
+// the process receives SIGHUP, until the session's context is done.
+func (s *Session) WatchConfig() {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	defer signal.Stop(hangups)
+
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+
+	lastError := ""
+	for {
+		select {
+		case <-s.Context.Done():
+			return
+		case <-hangups:
+			s.Log.Info("Received SIGHUP, reloading config")
+			if err := s.Reload(true); err != nil {
+				s.Log.Error("%s", err)
+			}
+		case <-ticker.C:
+			if err := s.Reload(false); err != nil {
+				if err.Error() != lastError {
+					s.Log.Error("%s", err)
+				}
+				lastError = err.Error()
+			} else {
+				lastError = ""
+			}
+		}
+	}
+}
+
+// Reload re-reads and validates the configuration and, if it changed (or
+// force is set) and is valid, swaps in the new config, signatures, views,
+// validators and CSV writers in one step, so no finding sees a mix of old and
+// new. An invalid configuration is rejected and the
+// running pipeline keeps using the previous one.
+func (s *Session) Reload(force bool) error {
+	config, err := ParseConfig(s.Options)
+	if err != nil {
+		return fmt.Errorf("Rejected config reload: %s", err)
+	}
+
+	current := s.CurrentConfig()
+	if config.Redaction.Salt == "" {
+		config.Redaction.Salt = current.Redaction.Salt
+	}
+	if !force && reflect.DeepEqual(config, current) {
+		return nil
+	}
+
+	var problems []string
+	for _, file := range config.Files {
+		fileProblems, err := ValidateConfigFile(file)
+		if err != nil {
+			return fmt.Errorf("Rejected config reload: %s", err)
+		}
+
+		for _, problem := range fileProblems {
+			problems = append(problems, problem.Error())
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("Rejected config reload, %d %s:\n%s", len(problems), Pluralize(len(problems), "problem", "problems"), strings.Join(problems, "\n"))
+	}
+
+	if err := s.prepareRedaction(&config.Redaction); err != nil {
+		return fmt.Errorf("Rejected config reload: %s", err)
+	}
+
+	signatures, err := CompileSignatures(config.Signatures, config.BlacklistedStrings, config.Redaction.Salt)
+	if err != nil {
+		return fmt.Errorf("Rejected config reload: %s", err)
+	}
+
+	views := newViews()
+	validators := s.newValidators()
+	files := s.openCsvFiles(signatures, views)
+
+	s.Lock()
+	previous := s.Config
+	s.Config = config
+	s.Signatures = signatures
+	s.Views = views
+	s.Validators = validators
+	s.adoptCsvFiles(files)
+	s.Unlock()
+
+	s.Log.Important("Reloaded config: %s", describeConfigChanges(previous, config))
+
+	if !reflect.DeepEqual(previous.GitHubAccessTokens, config.GitHubAccessTokens) {
+		s.Log.Warn("GitHub access tokens changed; restart to use the new tokens")
+	}
+
+	return nil
+}
+
+// describeConfigChanges summarises what a reload changed, for the log.
+func describeConfigChanges(previous *Config, next *Config) string {
+	var changes []string
+
+	before := make(map[string]ConfigSignature)
+	for _, signature := range previous.Signatures {
+		before[signature.Name] = signature
+	}
+
+	var added, changed []string
+	after := make(map[string]bool)
+	for _, signature := range next.Signatures {
+		after[signature.Name] = true
+		if old, exists := before[signature.Name]; !exists {
+			added = append(added, signature.Name)
+		} else if !reflect.DeepEqual(old, signature) {
+			changed = append(changed, signature.Name)
+		}
+	}
+
+	var removed []string
+	for _, signature := range previous.Signatures {
+		if !after[signature.Name] {
+			removed = append(removed, signature.Name)
+		}
+	}
+
+	for _, list := range []struct {
+		verb  string
+		names []string
+	}{{"added", added}, {"changed", changed}, {"removed", removed}} {
+		if len(list.names) > 0 {
+			changes = append(changes, fmt.Sprintf("%s %s %s", list.verb, Pluralize(len(list.names), "signature", "signatures"), strings.Join(list.names, ", ")))
+		}
+	}
+
+	for _, list := range []struct {
+		name          string
+		before, after []string
+	}{
+		{"blacklisted_strings", previous.BlacklistedStrings, next.BlacklistedStrings},
+		{"blacklisted_extensions", previous.BlacklistedExtensions, next.BlacklistedExtensions},
+		{"blacklisted_paths", previous.BlacklistedPaths, next.BlacklistedPaths},
+		{"blacklisted_entropy_extensions", previous.BlacklistedEntropyExtensions, next.BlacklistedEntropyExtensions},
+	} {
+		if !reflect.DeepEqual(list.before, list.after) {
+			changes = append(changes, fmt.Sprintf("%s %d -> %d entries", list.name, len(list.before), len(list.after)))
+		}
+	}
+
+	if previous.Redaction.Mode != next.Redaction.Mode {
+		changes = append(changes, fmt.Sprintf("redaction %s -> %s", previous.Redaction.Mode, next.Redaction.Mode))
+	}
+
+	if len(changes) == 0 {
+		return "no changes"
+	}
+
+	return strings.Join(changes, "; ")
+}
@@ -0,0 +1,533 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EntropySignature is the name given to findings of the entropy check.
+const EntropySignature = "High entropy string"
+
+// ScannerConfig is everything a Scanner needs. Unlike a Session it is not
+// built from command line flags, so programs embedding the scanner can fill
+// it in directly, start from DefaultScannerConfig or load a config.yaml with
+// LoadScannerConfig.
+type ScannerConfig struct {
+	Signatures                   []ConfigSignature
+	BlacklistedStrings           []string
+	BlacklistedExtensions        []string
+	BlacklistedPaths             []string
+	BlacklistedEntropyExtensions []string
+
+	SkipBinaryFiles   bool
+	Threads           int
+	MaximumFileSize   uint // KB
+	ScanArchives      bool
+	ArchiveMaxDepth   uint
+	ArchiveMaxEntries uint
+	ArchiveMaxSize    uint // KB
+	DecodeDepth       uint
+	EntropyThreshold  float64 // 0 disables the entropy check
+	PathChecks        bool
+	TempDirectory     string
+	CloneTimeout      time.Duration
+
+	// Salt keys finding fingerprints and the hashes of allowlisted secrets.
+	// Both only match the command line's with the same salt, which it takes
+	// from redaction.salt or generates and keeps in the cache directory.
+	Salt string
+	// Redaction is how Redact shows secrets.
+	Redaction Redaction
+
+	// Log receives debug messages. It is optional.
+	Log *Logger
+}
+
+// ScanTarget describes where scanned files came from. It is copied on to
+// every MatchEvent.
+type ScanTarget struct {
+	Url    string
+	Ref    string
+	Stars  int
+	Source GitResourceType
+}
+
+// Scanner matches signatures against files, directories and repositories.
+// It holds no global state, so several can be used side by side.
+type Scanner struct {
+	config     ScannerConfig
+	signatures []Signature
+	stats      *Stats
+}
+
+// DefaultScannerConfig returns the same defaults as the command line, with
+// no signatures.
+func DefaultScannerConfig() ScannerConfig {
+	config := defaultConfig()
+
+	return ScannerConfig{
+		SkipBinaryFiles:   config.SkipBinaryFiles.Local,
+		Threads:           runtime.NumCPU(),
+		MaximumFileSize:   256,
+		ScanArchives:      true,
+		ArchiveMaxDepth:   3,
+		ArchiveMaxEntries: 1000,
+		ArchiveMaxSize:    51200,
+		DecodeDepth:       2,
+		EntropyThreshold:  5.0,
+		PathChecks:        true,
+		TempDirectory:     filepath.Join(os.TempDir(), Name),
+		CloneTimeout:      10 * time.Second,
+		Redaction:         config.Redaction,
+	}
+}
+
+// LoadScannerConfig reads the signatures and blacklists from the
+// configuration file at path, including its includes and signature packs, on
+// top of DefaultScannerConfig.
+func LoadScannerConfig(path string) (ScannerConfig, error) {
+	config := defaultConfig()
+	if err := loadConfigFile(&config, path); err != nil {
+		return ScannerConfig{}, err
+	}
+	config.Redaction.Salt = os.ExpandEnv(config.Redaction.Salt)
+
+	scannerConfig := DefaultScannerConfig()
+	scannerConfig.applyConfig(&config)
+	scannerConfig.SkipBinaryFiles = config.SkipBinaryFiles.Local
+
+	return scannerConfig, nil
+}
+
+// NewScannerConfig builds the scanner configuration used by a session.
+func NewScannerConfig(config *Config, options *Options) ScannerConfig {
+	scannerConfig := ScannerConfig{
+		SkipBinaryFiles:   config.SkipBinaryFiles.Enabled(options),
+		Threads:           *options.Threads,
+		MaximumFileSize:   *options.MaximumFileSize,
+		ScanArchives:      *options.ScanArchives,
+		ArchiveMaxDepth:   *options.ArchiveMaxDepth,
+		ArchiveMaxEntries: *options.ArchiveMaxEntries,
+		ArchiveMaxSize:    *options.ArchiveMaxSize,
+		DecodeDepth:       *options.DecodeDepth,
+		EntropyThreshold:  *options.EntropyThreshold,
+		PathChecks:        *options.PathChecks,
+		TempDirectory:     *options.TempDirectory,
+		CloneTimeout:      time.Duration(*options.CloneRepositoryTimeout) * time.Second,
+	}
+	scannerConfig.applyConfig(config)
+
+	return scannerConfig
+}
+
+func (c *ScannerConfig) applyConfig(config *Config) {
+	c.Signatures = config.Signatures
+	c.BlacklistedStrings = config.BlacklistedStrings
+	c.BlacklistedExtensions = config.BlacklistedExtensions
+	c.BlacklistedPaths = config.BlacklistedPaths
+	c.BlacklistedEntropyExtensions = config.BlacklistedEntropyExtensions
+	c.Salt = config.Redaction.Salt
+	c.Redaction = config.Redaction
+}
+
+// NewScanner compiles the signatures in config and returns a scanner for
+// them. Unlike a session, an invalid signature is an error rather than being
+// skipped.
+func NewScanner(config ScannerConfig) (*Scanner, error) {
+	signatures, err := CompileSignatures(config.Signatures, config.BlacklistedStrings, config.Salt)
+	if err != nil {
+		return nil, err
+	}
+
+	return newScanner(config, signatures, &Stats{}), nil
+}
+
+func newScanner(config ScannerConfig, signatures []Signature, stats *Stats) *Scanner {
+	if config.Threads < 1 {
+		config.Threads = 1
+	}
+
+	return &Scanner{
+		config:     config,
+		signatures: signatures,
+		stats:      stats,
+	}
+}
+
+// Scanner returns a scanner for the session's current configuration and
+// signatures. It shares the session's stats.
+func (s *Session) Scanner() *Scanner {
+	s.Lock()
+	defer s.Unlock()
+
+	config := NewScannerConfig(s.Config, s.Options)
+	config.Log = s.Log
+
+	return newScanner(config, s.Signatures, s.Stats)
+}
+
+// Stats returns the scanner's counters.
+func (s *Scanner) Stats() *Stats {
+	return s.stats
+}
+
+// Fingerprint returns event's fingerprint, keyed with the scanner's salt.
+func (s *Scanner) Fingerprint(event *MatchEvent) string {
+	return event.Fingerprint(s.config.Salt)
+}
+
+// Redact applies the scanner's redaction policy to secret.
+func (s *Scanner) Redact(secret string) string {
+	return s.config.Redaction.Redact(secret)
+}
+
+// ScanBytes scans contents as if they were a file called name. Archives are
+// expanded and binary contents skipped as configured, but the blacklists for
+// extensions and paths are not applied.
+func (s *Scanner) ScanBytes(name string, contents []byte) []*MatchEvent {
+	var events []*MatchEvent
+	target := ScanTarget{Url: name, Source: LOCAL_SOURCE}
+
+	scan := func(file MatchFile) {
+		if s.config.SkipBinaryFiles && IsBinary(file.Contents) {
+			s.stats.IncBinaryFilesSkipped()
+			return
+		}
+		events = append(events, s.ScanFile(file, file.Path, target)...)
+	}
+
+	if s.config.ScanArchives && IsArchive(name) {
+		if err := s.expandArchive(name, contents, scan); err != nil {
+			s.debug("Stopped expanding archive %s: %s", name, err)
+		}
+		return events
+	}
+
+	_, filename := filepath.Split(filepath.ToSlash(name))
+	scan(MatchFile{
+		Path:      filepath.ToSlash(name),
+		Filename:  filename,
+		Extension: filepath.Ext(name),
+		Contents:  contents,
+	})
+
+	return events
+}
+
+// ScanDir scans every file under dir and returns the findings, with file
+// names relative to dir. It stops early if ctx is done.
+func (s *Scanner) ScanDir(ctx context.Context, dir string) ([]*MatchEvent, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+
+	var events []*MatchEvent
+	s.Walk(ctx, dir, ScanTarget{Url: dir, Source: LOCAL_SOURCE}, func(file MatchFile, name string, fileEvents []*MatchEvent) {
+		events = append(events, fileEvents...)
+	})
+
+	return events, nil
+}
+
+// ScanRepo makes a shallow clone of the repository at url, optionally at ref,
+// in to the temp directory, scans it and removes the clone.
+func (s *Scanner) ScanRepo(ctx context.Context, url string, ref string) ([]*MatchEvent, error) {
+	if err := os.MkdirAll(s.config.TempDirectory, 0755); err != nil {
+		return nil, err
+	}
+
+	dir, err := ioutil.TempDir(s.config.TempDirectory, "repository")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err := cloneRepository(ctx, url, ref, dir, s.config.CloneTimeout); err != nil {
+		s.stats.IncReposFailed()
+		return nil, err
+	}
+	s.stats.IncReposCloned()
+
+	var events []*MatchEvent
+	s.Walk(ctx, dir, ScanTarget{Url: url, Ref: ref, Source: GITHUB_SOURCE}, func(file MatchFile, name string, fileEvents []*MatchEvent) {
+		events = append(events, fileEvents...)
+	})
+
+	return events, nil
+}
+
+// Walk scans every file under dir and calls handle once per file, in the
+// order files are read, with the file's name relative to dir and its
+// findings (possibly none). It stops early if ctx is done.
+func (s *Scanner) Walk(ctx context.Context, dir string, target ScanTarget, handle func(file MatchFile, name string, events []*MatchEvent)) {
+	for file := range s.Files(ctx, dir) {
+		name := strings.TrimPrefix(strings.TrimPrefix(file.Path, filepath.ToSlash(dir)), "/")
+		handle(file, name, s.ScanFile(file, name, target))
+	}
+}
+
+// Files walks dir and streams every file that passes the size and
+// blacklist filters. Files are read by a pool of workers as they are consumed,
+// so only a bounded number of them are held in memory at any one time.
+// Once ctx is done the walk and the workers stop and the channel is closed,
+// so a consumer can stop reading without leaking them.
+func (s *Scanner) Files(ctx context.Context, dir string) <-chan MatchFile {
+	workers := s.config.Threads
+
+	paths := make(chan string, workers)
+	files := make(chan MatchFile, workers)
+	maxFileSize := s.config.MaximumFileSize * 1024
+	maxArchiveSize := s.config.ArchiveMaxSize * 1024
+
+	go func() {
+		defer close(paths)
+
+		filepath.Walk(dir, func(path string, f os.FileInfo, err error) error {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err != nil || f.IsDir() {
+				return nil
+			}
+
+			if s.isSkippable(path) {
+				s.stats.IncFilteredFilesSkipped()
+				return nil
+			}
+
+			if s.config.ScanArchives && IsArchive(path) {
+				if uint(f.Size()) > maxArchiveSize {
+					s.stats.IncFilteredFilesSkipped()
+					return nil
+				}
+			} else if uint(f.Size()) > maxFileSize {
+				s.stats.IncFilteredFilesSkipped()
+				return nil
+			}
+
+			select {
+			case paths <- path:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+
+	emit := func(file MatchFile) {
+		if s.config.SkipBinaryFiles && IsBinary(file.Contents) {
+			s.stats.IncBinaryFilesSkipped()
+			return
+		}
+		select {
+		case files <- file:
+		case <-ctx.Done():
+		}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for path := range paths {
+				if ctx.Err() != nil {
+					continue
+				}
+				if s.config.ScanArchives && IsArchive(path) {
+					contents, err := ioutil.ReadFile(path)
+					if err == nil {
+						err = s.expandArchive(path, contents, emit)
+					}
+					if err != nil {
+						s.debug("Stopped expanding archive %s: %s", path, err)
+					}
+					continue
+				}
+
+				emit(NewMatchFile(path))
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(files)
+	}()
+
+	return files
+}
+
+// ScanFile matches every signature against file and returns the findings
+// in signature order: contents and path matches, the entropy check, then
+// matches found in base64 or hex encoded blobs. name is the file name
+// reported in the findings.
+func (s *Scanner) ScanFile(file MatchFile, name string, target ScanTarget) []*MatchEvent {
+	s.stats.AddFileScanned(len(file.Contents))
+
+	var events []*MatchEvent
+	lines := NewLineIndex(file.Contents)
+	entropyChecked := false
+
+	newEvent := func(signature string, metadata SignatureMetadata, part string, match string, line int, column int) *MatchEvent {
+		return &MatchEvent{
+			SignatureMetadata: metadata,
+			Source:            target.Source,
+			Url:               target.Url,
+			Permalink:         GetPermalink(target.Url, target.Ref, name, line),
+			Match:             match,
+			Signature:         signature,
+			File:              name,
+			Line:              line,
+			Column:            column,
+			Stars:             target.Stars,
+			Part:              part,
+			LocalPath:         file.Path,
+		}
+	}
+
+	for _, signature := range s.signatures {
+		if len(signature.Search()) > 0 {
+			continue
+		}
+
+		matched, part := signature.Match(file)
+		if !matched {
+			continue
+		}
+
+		if part == PartContents {
+			for _, match := range signature.GetContentsMatches(file.Contents) {
+				if !HasAllowMarker(lines.Text(file.Contents, match.Line)) {
+					events = append(events, newEvent(signature.Name(), signature.Metadata(), part, match.Value, match.Line, match.Column))
+				}
+			}
+			continue
+		}
+
+		if s.config.PathChecks {
+			events = append(events, newEvent(signature.Name(), signature.Metadata(), part, "", 0, 0))
+		}
+
+		if !entropyChecked && s.config.EntropyThreshold > 0 && s.canCheckEntropy(file) {
+			entropyChecked = true
+			for _, event := range s.entropyMatches(file) {
+				events = append(events, newEvent(EntropySignature, EntropyMetadata, PartContents, event.Value, event.Line, event.Column))
+			}
+		}
+	}
+
+	for _, blob := range DecodeBlobs(file.Contents, int(s.config.DecodeDepth)) {
+		blobLine, blobColumn := lines.Position(blob.Offset)
+		if HasAllowMarker(lines.Text(file.Contents, blobLine)) {
+			continue
+		}
+
+		decoded := file
+		decoded.Contents = blob.Contents
+
+		for _, signature := range s.signatures {
+			if len(signature.Search()) > 0 {
+				continue
+			}
+
+			if matched, part := signature.Match(decoded); !matched || part != PartContents {
+				continue
+			}
+
+			for _, match := range signature.GetContentsMatches(blob.Contents) {
+				event := newEvent(signature.Name(), signature.Metadata(), PartContents, match.Value, blobLine, blobColumn)
+				event.Decoding = blob.ChainString()
+				events = append(events, event)
+			}
+		}
+	}
+
+	return events
+}
+
+// entropyMatches returns the lines of file whose Shannon entropy reaches
+// the threshold.
+func (s *Scanner) entropyMatches(file MatchFile) []ContentMatch {
+	var matches []ContentMatch
+	scanner := bufio.NewScanner(bytes.NewReader(file.Contents))
+
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := scanner.Text()
+
+		if len(line) <= 6 || len(line) >= 100 || HasAllowMarker([]byte(line)) {
+			continue
+		}
+
+		if GetEntropy(line) < s.config.EntropyThreshold || s.isBlacklisted(line) {
+			continue
+		}
+
+		matches = append(matches, ContentMatch{Value: line, Line: lineNumber, Column: 1})
+	}
+
+	return matches
+}
+
+func (s *Scanner) isBlacklisted(value string) bool {
+	value = strings.ToLower(value)
+	for _, blacklistedString := range s.config.BlacklistedStrings {
+		if strings.Contains(value, strings.ToLower(blacklistedString)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *Scanner) isSkippable(path string) bool {
+	extension := strings.ToLower(filepath.Ext(path))
+
+	for _, skippableExt := range s.config.BlacklistedExtensions {
+		if extension == skippableExt {
+			return true
+		}
+	}
+
+	for _, skippablePathIndicator := range s.config.BlacklistedPaths {
+		skippablePathIndicator = strings.Replace(skippablePathIndicator, "{sep}", string(os.PathSeparator), -1)
+		if strings.Contains(path, skippablePathIndicator) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *Scanner) canCheckEntropy(file MatchFile) bool {
+	if file.Filename == "id_rsa" {
+		return false
+	}
+
+	for _, skippableExt := range s.config.BlacklistedEntropyExtensions {
+		if file.Extension == skippableExt {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (s *Scanner) debug(format string, args ...interface{}) {
+	if s.config.Log != nil {
+		s.config.Log.Debug(format, args...)
+	}
+}
@@ -0,0 +1,177 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+)
+
+// seenSaveInterval is how many additions are buffered in memory before a
+// SeenSet is written back to disk.
+const seenSaveInterval = 500
+
+// SeenSet remembers the most recently seen keys, e.g. GitHub event IDs, up
+// to a fixed capacity, evicting the least recently seen key when full. It is
+// kept on disk one key per line, oldest first, so a restart does not process
+// the same events again.
+type SeenSet struct {
+	sync.Mutex
+
+	path     string
+	capacity int
+	order    *list.List
+	index    map[string]*list.Element
+	unsaved  int
+}
+
+// OpenSeenSet loads the set stored at path, if any.
+func OpenSeenSet(path string, capacity int) (*SeenSet, error) {
+	s := &SeenSet{
+		path:     path,
+		capacity: capacity,
+		order:    list.New(),
+		index:    make(map[string]*list.Element),
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if key := strings.TrimSpace(scanner.Text()); key != "" {
+			s.add(key)
+		}
+	}
+	s.unsaved = 0
+
+	return s, nil
+}
+
+// Add records key as seen and reports whether it was new.
+func (s *SeenSet) Add(key string) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	added := s.add(key)
+	if added {
+		s.unsaved++
+		if s.unsaved >= seenSaveInterval {
+			s.save()
+		}
+	}
+
+	return added
+}
+
+// Contains reports whether key has been seen, without refreshing it.
+func (s *SeenSet) Contains(key string) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	_, exists := s.index[key]
+	return exists
+}
+
+// Len returns the number of keys remembered.
+func (s *SeenSet) Len() int {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.order.Len()
+}
+
+// Save writes the set to disk.
+func (s *SeenSet) Save() error {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.save()
+}
+
+func (s *SeenSet) add(key string) bool {
+	if element, exists := s.index[key]; exists {
+		s.order.MoveToBack(element)
+		return false
+	}
+
+	s.index[key] = s.order.PushBack(key)
+	for s.capacity > 0 && s.order.Len() > s.capacity {
+		oldest := s.order.Front()
+		s.order.Remove(oldest)
+		delete(s.index, oldest.Value.(string))
+	}
+
+	return true
+}
+
+func (s *SeenSet) save() error {
+	var buffer bytes.Buffer
+	for element := s.order.Front(); element != nil; element = element.Next() {
+		buffer.WriteString(element.Value.(string))
+		buffer.WriteByte('\n')
+	}
+
+	if err := writeFileAtomic(s.path, buffer.Bytes()); err != nil {
+		return err
+	}
+
+	s.unsaved = 0
+	return nil
+}
+
+// Cursors persists small named positions, such as the newest GitHub event
+// processed, so polling resumes where it stopped.
+type Cursors struct {
+	sync.Mutex
+
+	path   string
+	values map[string]string
+}
+
+// OpenCursors loads the cursors stored at path, if any.
+func OpenCursors(path string) (*Cursors, error) {
+	c := &Cursors{path: path, values: make(map[string]string)}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return c, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &c.values); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Get returns the cursor called name, or "" if it was never set.
+func (c *Cursors) Get(name string) string {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.values[name]
+}
+
+// Set updates the cursor called name and writes all cursors to disk.
+func (c *Cursors) Set(name string, value string) error {
+	c.Lock()
+	defer c.Unlock()
+
+	c.values[name] = value
+	data, err := json.Marshal(c.values)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(c.path, data)
+}
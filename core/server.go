@@ -0,0 +1,456 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	JobQueued   = "queued"
+	JobRunning  = "running"
+	JobDone     = "done"
+	JobFailed   = "failed"
+	JobCanceled = "canceled"
+
+	// maxPendingJobs bounds the jobs waiting for a free slot; further
+	// submissions are refused until some finish.
+	maxPendingJobs = 1000
+
+	// jobRetention is how long finished jobs and their findings are kept.
+	jobRetention = time.Hour
+
+	// maxScanRequestSize bounds the body of a submission, content included.
+	maxScanRequestSize = 10 * 1024 * 1024
+)
+
+// ScanRequest is a submission to the scan API. Exactly one of Repository,
+// Path or Content is set.
+type ScanRequest struct {
+	Repository string `json:"repository,omitempty"`
+	Ref        string `json:"ref,omitempty"`
+	Path       string `json:"path,omitempty"`
+	Content    string `json:"content,omitempty"`
+	Name       string `json:"name,omitempty"`
+}
+
+// ScanJob is a scan submitted to the API and its progress.
+type ScanJob struct {
+	Id       string        `json:"id"`
+	Status   string        `json:"status"`
+	Target   string        `json:"target"`
+	Error    string        `json:"error,omitempty"`
+	Created  time.Time     `json:"created"`
+	Started  *time.Time    `json:"started,omitempty"`
+	Finished *time.Time    `json:"finished,omitempty"`
+	Count    int           `json:"findings_count"`
+	Findings []*MatchEvent `json:"findings,omitempty"`
+
+	request ScanRequest
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// ScanServer runs scans submitted over HTTP, a limited number at a time,
+// each in a temp directory of its own.
+type ScanServer struct {
+	sync.Mutex
+
+	session *Session
+	jobs    map[string]*ScanJob
+	slots   chan struct{}
+	pending int
+	next    uint64
+}
+
+// NewScanServer returns a server running up to --serve-jobs scans at once.
+func NewScanServer(session *Session) *ScanServer {
+	jobs := int(*session.Options.ServeJobs)
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	return &ScanServer{
+		session: session,
+		jobs:    make(map[string]*ScanJob),
+		slots:   make(chan struct{}, jobs),
+	}
+}
+
+// Serve serves the scan API on listener until the session stops.
+func (s *ScanServer) Serve(listener net.Listener) error {
+	return serveUntilDone(s.session.Context, listener, s)
+}
+
+// ServeHTTP implements the scan API:
+//
+//	POST   /v1/scans              submit a ScanRequest; ?wait=true answers once it finished
+//	GET    /v1/scans              list jobs, without findings
+//	GET    /v1/scans/ID           a job's status
+//	GET    /v1/scans/ID/findings  a finished job's findings
+//	DELETE /v1/scans/ID           cancel a job
+func (s *ScanServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !bearerAuthorized(r, s.session.CurrentConfig().ApiToken) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 2 || parts[0] != "v1" || parts[1] != "scans" || len(parts) > 4 {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch {
+	case len(parts) == 2 && r.Method == http.MethodPost:
+		s.handleSubmit(w, r)
+	case len(parts) == 2 && r.Method == http.MethodGet:
+		writeJSON(w, s.Jobs())
+	case len(parts) == 3 && r.Method == http.MethodGet:
+		if job, exists := s.Job(parts[2]); exists {
+			writeJSON(w, job)
+		} else {
+			http.NotFound(w, r)
+		}
+	case len(parts) == 3 && r.Method == http.MethodDelete:
+		if s.Cancel(parts[2]) {
+			w.WriteHeader(http.StatusNoContent)
+		} else {
+			http.NotFound(w, r)
+		}
+	case len(parts) == 4 && parts[3] == "findings" && r.Method == http.MethodGet:
+		findings, job, exists := s.Findings(parts[2])
+		switch {
+		case !exists:
+			http.NotFound(w, r)
+		case job.Finished == nil:
+			http.Error(w, fmt.Sprintf("scan is %s", job.Status), http.StatusConflict)
+		default:
+			writeJSON(w, findings)
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *ScanServer) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	var request ScanRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxScanRequestSize)).Decode(&request); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	id, err := s.Submit(request)
+	switch {
+	case err == errTooManyJobs:
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if wait, _ := strconv.ParseBool(r.URL.Query().Get("wait")); wait {
+		s.Lock()
+		done := s.jobs[id].done
+		s.Unlock()
+
+		select {
+		case <-done:
+		case <-r.Context().Done():
+			return
+		}
+
+		findings, job, _ := s.Findings(id)
+		job.Findings = findings
+		writeJSON(w, job)
+		return
+	}
+
+	job, _ := s.Job(id)
+	w.Header().Set("Location", "/v1/scans/"+id)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+var errTooManyJobs = errors.New("too many scans waiting, try again later")
+
+// Submit validates request and queues it, returning the job's ID.
+func (s *ScanServer) Submit(request ScanRequest) (string, error) {
+	target, err := s.validate(&request)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithCancel(s.session.Context)
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.purge()
+	if s.pending >= maxPendingJobs {
+		cancel()
+		return "", errTooManyJobs
+	}
+
+	s.next++
+	job := &ScanJob{
+		Id:      strconv.FormatUint(s.next, 10),
+		Status:  JobQueued,
+		Target:  target,
+		Created: time.Now(),
+		request: request,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+	s.jobs[job.Id] = job
+	s.pending++
+
+	go s.run(ctx, job)
+	return job.Id, nil
+}
+
+// validate checks that request names exactly one thing to scan, and that a
+// path is inside --serve-root. It returns a description of the target.
+func (s *ScanServer) validate(request *ScanRequest) (string, error) {
+	set := 0
+	for _, value := range []string{request.Repository, request.Path, request.Content} {
+		if value != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return "", errors.New("exactly one of repository, path or content is required")
+	}
+
+	switch {
+	case request.Repository != "":
+		if err := s.checkRepository(request.Repository); err != nil {
+			return "", err
+		}
+		return request.Repository, nil
+	case request.Path != "":
+		path, err := s.resolvePath(request.Path)
+		if err != nil {
+			return "", err
+		}
+		request.Path = path
+		return path, nil
+	default:
+		if request.Name == "" {
+			request.Name = "content"
+		}
+		return request.Name, nil
+	}
+}
+
+// checkRepository makes sure a repository submitted for scanning is an
+// http(s) URL on one of --serve-hosts, so the server only clones from hosts
+// it is meant to.
+func (s *ScanServer) checkRepository(repository string) error {
+	u, err := url.Parse(repository)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Hostname() == "" {
+		return errors.New("repository must be an http(s) URL")
+	}
+
+	for _, host := range strings.Split(*s.session.Options.ServeHosts, ",") {
+		if host = strings.TrimSpace(host); host != "" && strings.EqualFold(host, u.Hostname()) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("repositories on %s are not allowed, see --serve-hosts", u.Hostname())
+}
+
+// resolvePath returns the real path of a path submitted for scanning, which
+// must be inside --serve-root once symlinks are followed.
+func (s *ScanServer) resolvePath(path string) (string, error) {
+	root := *s.session.Options.ServeRoot
+	if root == "" {
+		return "", errors.New("path scans are disabled, start the server with --serve-root to allow them")
+	}
+
+	root, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", fmt.Errorf("invalid --serve-root: %s", err)
+	}
+
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(root, path)
+	}
+
+	path, err = filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+
+	if relative, err := filepath.Rel(root, path); err != nil || relative == ".." || strings.HasPrefix(relative, ".."+string(os.PathSeparator)) {
+		return "", fmt.Errorf("%s is outside the serve root", path)
+	}
+
+	return path, nil
+}
+
+func (s *ScanServer) run(ctx context.Context, job *ScanJob) {
+	defer close(job.done)
+	defer job.cancel()
+
+	select {
+	case s.slots <- struct{}{}:
+		defer func() { <-s.slots }()
+	case <-ctx.Done():
+		s.finish(job, nil, ctx.Err())
+		return
+	}
+
+	s.Lock()
+	started := time.Now()
+	job.Status = JobRunning
+	job.Started = &started
+	s.Unlock()
+
+	scanner := s.session.Scanner()
+
+	var (
+		events []*MatchEvent
+		err    error
+	)
+	switch request := job.request; {
+	case request.Repository != "":
+		events, err = scanner.ScanRepo(ctx, request.Repository, request.Ref)
+	case request.Path != "":
+		events, err = scanner.ScanDir(ctx, request.Path)
+	default:
+		events = scanner.ScanBytes(request.Name, []byte(request.Content))
+	}
+
+	if err == nil {
+		err = ctx.Err()
+	}
+
+	s.finish(job, events, err)
+}
+
+func (s *ScanServer) finish(job *ScanJob, events []*MatchEvent, err error) {
+	var findings []*MatchEvent
+	for _, event := range events {
+		if s.session.Baseline.Contains(event) || !s.session.IsReportable(event) {
+			continue
+		}
+
+		s.session.Stats.RecordFinding(event)
+		redacted := *event
+		redacted.Match = s.session.Redact(event.Match)
+		findings = append(findings, &redacted)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	finished := time.Now()
+	job.Finished = &finished
+	job.Findings = findings
+	job.Count = len(findings)
+	job.request.Content = ""
+	s.pending--
+
+	switch {
+	case err == context.Canceled:
+		job.Status = JobCanceled
+	case err != nil:
+		job.Status = JobFailed
+		job.Error = err.Error()
+	default:
+		job.Status = JobDone
+	}
+
+	s.session.Log.Info("Scan %s of %s %s with %d %s", job.Id, job.Target, job.Status, job.Count, Pluralize(job.Count, "finding", "findings"))
+}
+
+// Job returns a copy of the job with id, without its findings.
+func (s *ScanServer) Job(id string) (ScanJob, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	job, exists := s.jobs[id]
+	if !exists {
+		return ScanJob{}, false
+	}
+
+	return job.summary(), true
+}
+
+// Jobs returns every job still kept, oldest first, without findings.
+func (s *ScanServer) Jobs() []ScanJob {
+	s.Lock()
+	defer s.Unlock()
+
+	jobs := make([]ScanJob, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, job.summary())
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Created.Before(jobs[j].Created) })
+
+	return jobs
+}
+
+// Findings returns the redacted findings of the job with id, and the job.
+func (s *ScanServer) Findings(id string) ([]*MatchEvent, ScanJob, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	job, exists := s.jobs[id]
+	if !exists {
+		return nil, ScanJob{}, false
+	}
+
+	findings := append([]*MatchEvent{}, job.Findings...)
+	return findings, job.summary(), true
+}
+
+// Cancel stops the job with id if it is still queued or cloning.
+func (s *ScanServer) Cancel(id string) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	job, exists := s.jobs[id]
+	if exists {
+		job.cancel()
+	}
+
+	return exists
+}
+
+// purge forgets jobs that finished more than jobRetention ago.
+func (s *ScanServer) purge() {
+	for id, job := range s.jobs {
+		if job.Finished != nil && time.Since(*job.Finished) > jobRetention {
+			delete(s.jobs, id)
+		}
+	}
+}
+
+func (j *ScanJob) summary() ScanJob {
+	return ScanJob{
+		Id:       j.Id,
+		Status:   j.Status,
+		Target:   j.Target,
+		Error:    j.Error,
+		Created:  j.Created,
+		Started:  j.Started,
+		Finished: j.Finished,
+		Count:    j.Count,
+	}
+}
@@ -2,11 +2,17 @@ package core
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
 	"encoding/csv"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"runtime"
+	"strings"
 	"sync"
 	"time"
 
@@ -26,6 +32,8 @@ type ValidationInfo map[string]string
 type Validator func(signature string, match string) (bool, ValidationInfo, Relevance)
 type CsvWriters map[string]*csv.Writer
 
+var csvColumns = []string{"File", "Line", "Column", "Match", "URL", "Fingerprint", "Severity", "Tags", "Confidence"}
+
 type Session struct {
 	sync.Mutex
 
@@ -34,16 +42,28 @@ type Session struct {
 	Options          *Options
 	Config           *Config
 	Signatures       []Signature
-	Repositories     chan GitResource
-	Gists            chan string
-	Comments         chan string
-	SearchResults    chan SearchResult
+	Repositories     *Queue
+	Gists            *Queue
+	Comments         *Queue
+	SearchResults    *Queue
+	Seen             *SeenSet
+	Cursors          *Cursors
 	Context          context.Context
 	Clients          chan *GitHubClientWrapper
 	ExhaustedClients chan *GitHubClientWrapper
 	Views            map[string][]string
 	Validators       map[string]Validator
 	CsvWriters       CsvWriters
+	Stats            *Stats
+	Baseline         *Baseline
+	Triage           *Triage
+	Findings         *FingerprintIndex
+	Live             *LiveDashboard
+	Workers          sync.WaitGroup
+
+	cancel   context.CancelFunc
+	csvFiles []*os.File
+	tempDirs map[string]bool
 }
 
 var (
@@ -56,12 +76,49 @@ func (s *Session) Start() {
 	rand.Seed(time.Now().Unix())
 
 	s.InitLogger()
+	s.InitRedaction()
 	s.InitViews()
 	s.InitValidators()
 	s.InitThreads()
 	s.InitSignatures()
 	s.InitGitHubClients()
 	s.InitCsvWriters()
+	s.InitBaseline()
+
+	if len(*s.Options.Local) <= 0 {
+		if len(*s.Options.Worker) <= 0 && len(*s.Options.Serve) <= 0 {
+			s.InitTriage()
+			s.LoadCsvs()
+			s.InitQueues()
+		}
+		go s.WatchConfig()
+	}
+}
+
+// IsReportable applies the --minimum-severity and --tags filters to event.
+func (s *Session) IsReportable(event *MatchEvent) bool {
+	if *s.Options.MinimumSeverity != "" && SeverityRank(event.Severity) < SeverityRank(*s.Options.MinimumSeverity) {
+		return false
+	}
+
+	if *s.Options.Tags != "" && !event.HasTag(strings.Split(*s.Options.Tags, ",")...) {
+		return false
+	}
+
+	return true
+}
+
+func (s *Session) InitBaseline() {
+	if len(*s.Options.Baseline) <= 0 {
+		if *s.Options.UpdateBaseline {
+			s.Log.Fatal("--update-baseline requires a --baseline file.")
+		}
+		return
+	}
+
+	if s.Baseline, err = LoadBaseline(*s.Options.Baseline, s.Config.Redaction.Salt); err != nil {
+		s.Log.Fatal("Could not load baseline: %s", err)
+	}
 }
 
 func (s *Session) InitLogger() {
@@ -71,17 +128,26 @@ func (s *Session) InitLogger() {
 }
 
 func (s *Session) InitSignatures() {
+	for _, file := range s.Config.Files {
+		if problems, err := ValidateConfigFile(file); err == nil {
+			for _, problem := range problems {
+				s.Log.Warn("%s", problem)
+			}
+		}
+	}
+
 	s.Signatures = GetSignatures(s)
 }
 
 func (s *Session) InitGitHubClients() {
-	if len(*s.Options.Local) <= 0 {
+	if len(*s.Options.Local) <= 0 && len(*s.Options.Serve) <= 0 {
 		chanSize := *s.Options.Threads * (len(s.Config.GitHubAccessTokens) + 1)
 		s.Clients = make(chan *GitHubClientWrapper, chanSize)
 		s.ExhaustedClients = make(chan *GitHubClientWrapper, chanSize)
 		for _, token := range s.Config.GitHubAccessTokens {
 			ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})
 			tc := oauth2.NewClient(s.Context, ts)
+			tc.Transport = &rateLimitTransport{base: tc.Transport, token: s.TokenLabel(token), stats: s.Stats}
 
 			client := github.NewClient(tc)
 			client.UserAgent = fmt.Sprintf("%s v%s", Name, Version)
@@ -105,6 +171,15 @@ func (s *Session) InitGitHubClients() {
 	}
 }
 
+// TokenLabel is how a GitHub token is referred to in stats and metrics: a
+// short hash of it keyed with the redaction salt. Unlike its redacted value,
+// this reveals nothing of the token whatever the redaction mode.
+func (s *Session) TokenLabel(token string) string {
+	mac := hmac.New(sha256.New, []byte(s.CurrentConfig().Redaction.Salt))
+	mac.Write([]byte(token))
+	return "token-" + hex.EncodeToString(mac.Sum(nil))[:8]
+}
+
 func (s *Session) GetClient() *GitHubClientWrapper {
 	for {
 		select {
@@ -160,11 +235,46 @@ func (s *Session) getCsvDir() string {
 	return csvDir
 }
 
+// InitCsvWriters opens a CSV for every signature that does not have one yet,
+// so it can be called again after new signatures are loaded.
 func (s *Session) InitCsvWriters() {
-	s.CsvWriters = make(CsvWriters)
+	s.Lock()
+	signatures, views := s.Signatures, s.Views
+	s.Unlock()
+
+	files := s.openCsvFiles(signatures, views)
+
+	s.Lock()
+	s.adoptCsvFiles(files)
+	s.Unlock()
+}
+
+// openCsvFiles opens, and writes the header of, the CSV of every signature
+// that does not have a writer yet. The files are only written to once
+// adoptCsvFiles has made writers of them.
+func (s *Session) openCsvFiles(signatures []Signature, views map[string][]string) map[string]*os.File {
+	s.Lock()
+	existing := make(map[string]bool, len(s.CsvWriters))
+	for name := range s.CsvWriters {
+		existing[name] = true
+	}
+	s.Unlock()
+
+	files := make(map[string]*os.File)
 	csvDir := s.getCsvDir()
-	for _, signature := range s.Signatures {
+	for _, signature := range signatures {
+		if existing[signature.Name()] || files[signature.Name()] != nil {
+			continue
+		}
+
 		csvPath := fmt.Sprintf("%s%c%s.csv", csvDir, os.PathSeparator, signature.Name())
+		header := append([]string{}, csvColumns...)
+		header = append(header, views[signature.Name()]...)
+
+		if err := rotateCsv(csvPath, header); err != nil {
+			fmt.Println("Could not rotate CSV file:", err)
+			continue
+		}
 
 		writeHeader := false
 		if !PathExists(csvPath) {
@@ -175,64 +285,152 @@ func (s *Session) InitCsvWriters() {
 		if err != nil {
 			fmt.Println("Could not create CSV file:", err)
 			continue
-		} else {
+		}
+
+		if writeHeader {
 			writer := csv.NewWriter(file)
-			s.CsvWriters[signature.Name()] = writer
-			if writeHeader {
-				header := []string{"File", "Match", "URL"}
-				header = append(header, s.Views[signature.Name()]...)
-				writer.Write(header)
-				writer.Flush()
-			}
+			writer.Write(header)
+			writer.Flush()
+		}
+		files[signature.Name()] = file
+	}
+
+	return files
+}
 
+// adoptCsvFiles makes writers of files opened by openCsvFiles. Files for
+// signatures that got a writer in the meantime are closed. The caller must
+// hold the session lock.
+func (s *Session) adoptCsvFiles(files map[string]*os.File) {
+	if s.CsvWriters == nil {
+		s.CsvWriters = make(CsvWriters)
+	}
+
+	for name, file := range files {
+		if _, exists := s.CsvWriters[name]; exists {
+			file.Close()
+			continue
 		}
+
+		s.csvFiles = append(s.csvFiles, file)
+		s.CsvWriters[name] = csv.NewWriter(file)
+	}
+}
+
+// rotateCsv moves the CSV at path aside, to path.old-<time>, if its header is
+// not header, so that rows with different columns are never appended to it.
+// LoadCsvs still reads the rotated files.
+func rotateCsv(path string, header []string) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	existing, err := reader.Read()
+	file.Close()
+	if err == io.EOF {
+		return nil
+	}
+
+	if err == nil && strings.Join(existing, ",") == strings.Join(header, ",") {
+		return nil
 	}
+
+	return os.Rename(path, fmt.Sprintf("%s.old-%s", path, time.Now().Format("20060102150405")))
 }
 
 func (s *Session) WriteToCsv(event *MatchEvent) {
+	s.Lock()
 	writer, exists := s.CsvWriters[event.Signature]
+	view := s.Views[event.Signature]
+	redaction := s.Config.Redaction
+	s.Unlock()
+
 	if exists == false {
 		return
 	}
 
-	line := []string{
-		event.File,
-		event.Match,
-		event.Url,
+	var line []string
+	for _, column := range csvColumns {
+		value, _ := event.GetColumn(column, redaction)
+		line = append(line, value)
 	}
 
-	for _, v := range event.AdditionalInfo {
-		line = append(line, v)
+	for _, column := range view {
+		value, _ := event.GetColumn(column, redaction)
+		line = append(line, value)
 	}
 
+	s.Lock()
+	defer s.Unlock()
+
 	writer.Write(line)
 	writer.Flush()
 }
 
+// LoadCsvs seeds the findings index with the fingerprints of everything
+// already written to the CSVs, including those rotated by InitCsvWriters, so
+// findings are not reported again after a restart.
 func (s *Session) LoadCsvs() {
 	csvDir := s.getCsvDir()
 	for _, signature := range s.Signatures {
-		csvPath := fmt.Sprintf("%s%cAetherKey%c%s.csv", csvDir, os.PathSeparator, os.PathSeparator, signature.Name())
-		file, err := os.Open(csvPath)
-		if err != nil {
-			s.Log.Error("Could not open CSV file: %s.", err)
-			continue
-		} else {
-			defer file.Close()
-			reader := csv.NewReader(file)
-			reader.ReadAll()
+		csvPath := fmt.Sprintf("%s%c%s.csv", csvDir, os.PathSeparator, signature.Name())
+		rotated, _ := filepath.Glob(csvPath + ".old-*")
+
+		for _, path := range append([]string{csvPath}, rotated...) {
+			if err := s.loadCsv(path); err != nil {
+				s.Log.Error("Could not open CSV file: %s.", err)
+			}
 		}
 	}
 }
 
+func (s *Session) loadCsv(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil || len(records) < 1 {
+		return nil
+	}
+
+	column := -1
+	for i, name := range records[0] {
+		if name == "Fingerprint" {
+			column = i
+		}
+	}
+
+	if column == -1 {
+		return nil
+	}
+
+	for _, record := range records[1:] {
+		if column < len(record) {
+			s.Findings.Add(record[column])
+		}
+	}
+
+	return nil
+}
+
 func GetSession() *Session {
 	sessionSync.Do(func() {
+		ctx, cancel := context.WithCancel(context.Background())
 		session = &Session{
-			Context:       context.Background(),
-			Repositories:  make(chan GitResource, 1000),
-			Gists:         make(chan string, 100),
-			Comments:      make(chan string, 1000),
-			SearchResults: make(chan SearchResult, 1000),
+			Context:  ctx,
+			cancel:   cancel,
+			Stats:    &Stats{},
+			Findings: NewFingerprintIndex(),
 		}
 
 		if session.Options, err = ParseOptions(); err != nil {
@@ -0,0 +1,114 @@
+package core
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// drainTimeout is how long workers get to finish what they are scanning
+// after a shutdown is requested.
+const drainTimeout = 15 * time.Second
+
+// HandleSignals stops the session on SIGINT or SIGTERM. The UI is stopped
+// too, which returns control to main to finish the shutdown. A second signal
+// exits at once, without waiting for the shutdown.
+func (s *Session) HandleSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		received := <-signals
+		s.Log.Important("Received %s, shutting down. Send it again to exit immediately", received)
+		s.Stop()
+
+		if ui := GetUI(); ui.App != nil {
+			ui.App.Stop()
+		}
+
+		received = <-signals
+		s.Log.Important("Received %s again, exiting", received)
+		os.Exit(1)
+	}()
+}
+
+// Stop cancels the session's context. Producers stop polling GitHub and
+// workers stop taking new work; nothing is waited for.
+func (s *Session) Stop() {
+	if s.cancel != nil {
+		s.cancel()
+	}
+}
+
+// Shutdown stops the session and cleans up after it: it waits up to
+// drainTimeout for workers to finish what they are scanning, saves the queue
+// positions, flushes and closes the CSVs and removes the directories this
+// process created in the temp directory.
+// Work that did not finish stays queued for the next run.
+func (s *Session) Shutdown() {
+	s.Stop()
+
+	drained := make(chan struct{})
+	go func() {
+		s.Workers.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+	case <-time.After(drainTimeout):
+		s.Log.Warn("Workers did not finish within %s, abandoning in-flight scans", drainTimeout)
+	}
+
+	s.CloseQueues()
+	s.CloseCsvWriters()
+
+	s.removeTempDirs()
+}
+
+func (s *Session) trackTempDir(dir string) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.tempDirs == nil {
+		s.tempDirs = make(map[string]bool)
+	}
+	s.tempDirs[dir] = true
+}
+
+// removeTempDirs removes the directories handed out by GetTempDir. The temp
+// directory itself may be shared or chosen by the user, so it is left alone.
+func (s *Session) removeTempDirs() {
+	s.Lock()
+	dirs := s.tempDirs
+	s.tempDirs = nil
+	s.Unlock()
+
+	for dir := range dirs {
+		if err := os.RemoveAll(dir); err != nil {
+			s.Log.Error("Could not clean up %s: %s", dir, err)
+		}
+	}
+}
+
+// CloseCsvWriters flushes and closes every CSV. Findings published after
+// this are no longer written.
+func (s *Session) CloseCsvWriters() {
+	s.Lock()
+	defer s.Unlock()
+
+	for name, writer := range s.CsvWriters {
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			s.Log.Error("Could not write CSV for %s: %s", name, err)
+		}
+	}
+
+	for _, file := range s.csvFiles {
+		file.Close()
+	}
+
+	s.CsvWriters = make(CsvWriters)
+	s.csvFiles = nil
+}
@@ -1,8 +1,10 @@
 package core
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"regexp"
-	"regexp/syntax"
 	"strings"
 )
 
@@ -14,27 +16,141 @@ const (
 	PartFilename  = "filename"
 	PartPath      = "path"
 	PartContents  = "contents"
+
+	SeverityInfo     = "info"
+	SeverityLow      = "low"
+	SeverityMedium   = "medium"
+	SeverityHigh     = "high"
+	SeverityCritical = "critical"
+
+	ConfidenceLow    = "low"
+	ConfidenceMedium = "medium"
+	ConfidenceHigh   = "high"
 )
 
+var severityRanks = map[string]int{
+	SeverityInfo:     0,
+	SeverityLow:      1,
+	SeverityMedium:   2,
+	SeverityHigh:     3,
+	SeverityCritical: 4,
+}
+
 type Signature interface {
 	Name() string
 	Match(file MatchFile) (bool, string)
-	GetContentsMatches(contents []byte) []string
+	GetContentsMatches(contents []byte) []ContentMatch
 	Search() string
+	Metadata() SignatureMetadata
+}
+
+// SignatureMetadata describes a signature for triage. It is copied on to
+// every MatchEvent the signature produces.
+type SignatureMetadata struct {
+	Severity    string
+	Tags        []string
+	Description string
+	Remediation string
+	Confidence  string
+}
+
+// SeverityRank orders severities from info (0) to critical (4). Unknown
+// severities rank as medium.
+func SeverityRank(severity string) int {
+	if rank, exists := severityRanks[strings.ToLower(severity)]; exists {
+		return rank
+	}
+
+	return severityRanks[SeverityMedium]
+}
+
+// HasTag reports whether the metadata carries any of tags.
+func (m SignatureMetadata) HasTag(tags ...string) bool {
+	for _, tag := range tags {
+		for _, own := range m.Tags {
+			if strings.EqualFold(tag, own) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// EntropyMetadata describes the built-in "High entropy string" check.
+var EntropyMetadata = SignatureMetadata{
+	Severity:    SeverityLow,
+	Tags:        []string{"entropy"},
+	Description: "A line with unusually high Shannon entropy that may be a secret",
+	Confidence:  ConfidenceLow,
+}
+
+// ContentMatch is a single match of a signature in file contents. Line and
+// Column are 1-based; Column counts bytes from the start of the line.
+type ContentMatch struct {
+	Value  string
+	Line   int
+	Column int
 }
 
 type SimpleSignature struct {
-	part   string
-	match  string
-	name   string
-	search string
+	part      string
+	match     string
+	name      string
+	search    string
+	allowlist signatureAllowlist
+	metadata  SignatureMetadata
 }
 
 type PatternSignature struct {
-	part   string
-	match  *regexp.Regexp
-	name   string
-	search string
+	part        string
+	match       *regexp.Regexp
+	name        string
+	search      string
+	metadata    SignatureMetadata
+	keywords    [][]byte
+	entropy     float64
+	secretGroup int
+	allowlist   signatureAllowlist
+	blacklist   []string
+}
+
+// signatureAllowlist holds the compiled form of ConfigAllowlist.
+type signatureAllowlist struct {
+	regexes   []*regexp.Regexp
+	paths     []*regexp.Regexp
+	stopwords []string
+	secrets   map[string]bool
+	salt      string
+}
+
+var secretHashPattern = regexp.MustCompile(`^sha256:[0-9a-f]{32}$`)
+
+func (a signatureAllowlist) allowsPath(path string) bool {
+	for _, pattern := range a.paths {
+		if pattern.MatchString(path) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (a signatureAllowlist) allowsSecret(secret string) bool {
+	for _, pattern := range a.regexes {
+		if pattern.MatchString(secret) {
+			return true
+		}
+	}
+
+	lower := strings.ToLower(secret)
+	for _, stopword := range a.stopwords {
+		if strings.Contains(lower, stopword) {
+			return true
+		}
+	}
+
+	return len(a.secrets) > 0 && a.secrets[SecretHash(a.salt, secret)]
 }
 
 func (s SimpleSignature) Match(file MatchFile) (bool, string) {
@@ -43,6 +159,10 @@ func (s SimpleSignature) Match(file MatchFile) (bool, string) {
 		matchPart = ""
 	)
 
+	if s.allowlist.allowsPath(file.Path) {
+		return false, s.part
+	}
+
 	switch s.part {
 	case PartPath:
 		haystack = &file.Path
@@ -60,7 +180,7 @@ func (s SimpleSignature) Match(file MatchFile) (bool, string) {
 	return (s.match == *haystack), matchPart
 }
 
-func (s SimpleSignature) GetContentsMatches(contents []byte) []string {
+func (s SimpleSignature) GetContentsMatches(contents []byte) []ContentMatch {
 	return nil
 }
 
@@ -72,12 +192,20 @@ func (s SimpleSignature) Search() string {
 	return s.search
 }
 
+func (s SimpleSignature) Metadata() SignatureMetadata {
+	return s.metadata
+}
+
 func (s PatternSignature) Match(file MatchFile) (bool, string) {
 	var (
 		haystack  *string
 		matchPart = ""
 	)
 
+	if s.allowlist.allowsPath(file.Path) {
+		return false, s.part
+	}
+
 	switch s.part {
 	case PartPath:
 		haystack = &file.Path
@@ -89,6 +217,9 @@ func (s PatternSignature) Match(file MatchFile) (bool, string) {
 		haystack = &file.Extension
 		matchPart = PartExtension
 	case PartContents:
+		if !s.hasKeyword(file.Contents) {
+			return false, PartContents
+		}
 		return s.match.Match(file.Contents), PartContents
 	default:
 		return false, matchPart
@@ -97,21 +228,48 @@ func (s PatternSignature) Match(file MatchFile) (bool, string) {
 	return s.match.MatchString(*haystack), matchPart
 }
 
-func (s PatternSignature) GetContentsMatches(contents []byte) []string {
-	matches := make([]string, 0)
+// hasKeyword is a cheap pre-filter: when keywords are configured, contents
+// must contain at least one of them (case insensitive) to be worth matching.
+func (s PatternSignature) hasKeyword(contents []byte) bool {
+	if len(s.keywords) == 0 {
+		return true
+	}
+
+	lower := bytes.ToLower(contents)
+	for _, keyword := range s.keywords {
+		if bytes.Contains(lower, keyword) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s PatternSignature) GetContentsMatches(contents []byte) []ContentMatch {
+	matches := make([]ContentMatch, 0)
+	lines := NewLineIndex(contents)
 
-	for _, match := range s.match.FindAllSubmatch(contents, -1) {
-		match := string(match[0])
-		blacklistedMatch := false
+	if !s.hasKeyword(contents) {
+		return matches
+	}
+
+	for _, loc := range s.match.FindAllSubmatchIndex(contents, -1) {
+		if s.secretGroup > 0 && loc[2*s.secretGroup] >= 0 {
+			loc = loc[2*s.secretGroup : 2*s.secretGroup+2]
+		}
+
+		match := string(contents[loc[0]:loc[1]])
+		blacklistedMatch := s.allowlist.allowsSecret(match) || (s.entropy > 0 && GetEntropy(match) < s.entropy)
 
-		for _, blacklistedString := range session.Config.BlacklistedStrings {
-			if strings.Contains(strings.ToLower(match), strings.ToLower(blacklistedString)) {
+		for _, blacklistedString := range s.blacklist {
+			if strings.Contains(strings.ToLower(match), blacklistedString) {
 				blacklistedMatch = true
 			}
 		}
 
 		if !blacklistedMatch {
-			matches = append(matches, match)
+			line, column := lines.Position(loc[0])
+			matches = append(matches, ContentMatch{Value: match, Line: line, Column: column})
 		}
 	}
 
@@ -126,27 +284,142 @@ func (s PatternSignature) Search() string {
 	return s.search
 }
 
+func (s PatternSignature) Metadata() SignatureMetadata {
+	return s.metadata
+}
+
+// NewSignature compiles a signature from its configuration.
+func NewSignature(signature ConfigSignature) (Signature, error) {
+	return newSignature(signature, nil, "")
+}
+
+// newSignature compiles a signature whose contents matches are dropped when
+// they contain any of blacklistedStrings. Allowlisted secret hashes are keyed
+// with salt.
+func newSignature(signature ConfigSignature, blacklistedStrings []string, salt string) (Signature, error) {
+	if !isOneOf(signature.Part, validParts) {
+		return nil, fmt.Errorf("unknown part %q", signature.Part)
+	}
+
+	if signature.Match == "" && signature.Regex == "" {
+		return nil, errors.New("empty pattern, set either match or regex")
+	}
+
+	allowlist, err := compileAllowlist(signature.Allowlist, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	if signature.Match != "" {
+		return SimpleSignature{
+			name:      signature.Name,
+			part:      signature.Part,
+			match:     signature.Match,
+			search:    signature.Search,
+			metadata:  signature.Metadata(),
+			allowlist: allowlist,
+		}, nil
+	}
+
+	match, err := regexp.Compile(signature.Regex)
+	if err != nil {
+		return nil, err
+	}
+
+	if signature.SecretGroup < 0 || signature.SecretGroup > match.NumSubexp() {
+		return nil, fmt.Errorf("secret_group %d does not exist, the regex has %d groups", signature.SecretGroup, match.NumSubexp())
+	}
+
+	var keywords [][]byte
+	for _, keyword := range signature.Keywords {
+		keywords = append(keywords, []byte(strings.ToLower(keyword)))
+	}
+
+	return PatternSignature{
+		name:        signature.Name,
+		part:        signature.Part,
+		match:       match,
+		search:      signature.Search,
+		metadata:    signature.Metadata(),
+		keywords:    keywords,
+		entropy:     signature.Entropy,
+		secretGroup: signature.SecretGroup,
+		allowlist:   allowlist,
+		blacklist:   lowerAll(blacklistedStrings),
+	}, nil
+}
+
+func compileAllowlist(config ConfigAllowlist, salt string) (signatureAllowlist, error) {
+	allowlist := signatureAllowlist{salt: salt}
+
+	for _, pattern := range config.Regexes {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return allowlist, fmt.Errorf("allowlist regex: %s", err)
+		}
+		allowlist.regexes = append(allowlist.regexes, compiled)
+	}
+
+	for _, pattern := range config.Paths {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return allowlist, fmt.Errorf("allowlist path: %s", err)
+		}
+		allowlist.paths = append(allowlist.paths, compiled)
+	}
+
+	for _, stopword := range config.Stopwords {
+		allowlist.stopwords = append(allowlist.stopwords, strings.ToLower(stopword))
+	}
+
+	for _, hash := range config.Secrets {
+		if !secretHashPattern.MatchString(hash) {
+			return allowlist, fmt.Errorf("allowlist secret %q: expected sha256: and 32 hex digits, as shown by the hash redaction mode", hash)
+		}
+		if allowlist.secrets == nil {
+			allowlist.secrets = make(map[string]bool)
+		}
+		allowlist.secrets[hash] = true
+	}
+
+	return allowlist, nil
+}
+
+// CompileSignatures compiles every signature in signatures, stopping at the
+// first one that fails.
+func CompileSignatures(signatures []ConfigSignature, blacklistedStrings []string, salt string) ([]Signature, error) {
+	var compiled []Signature
+	for _, configSignature := range signatures {
+		signature, err := newSignature(configSignature, blacklistedStrings, salt)
+		if err != nil {
+			return nil, fmt.Errorf("signature %s: %s", configSignature.Name, err)
+		}
+		compiled = append(compiled, signature)
+	}
+
+	return compiled, nil
+}
+
 func GetSignatures(s *Session) []Signature {
 	var signatures []Signature
 	for _, signature := range s.Config.Signatures {
-		if signature.Match != "" {
-			signatures = append(signatures, SimpleSignature{
-				name:   signature.Name,
-				part:   signature.Part,
-				match:  signature.Match,
-				search: signature.Search,
-			})
-		} else {
-			if _, err := syntax.Parse(signature.Match, syntax.FoldCase); err == nil {
-				signatures = append(signatures, PatternSignature{
-					name:   signature.Name,
-					part:   signature.Part,
-					match:  regexp.MustCompile(signature.Regex),
-					search: signature.Search,
-				})
-			}
+		compiled, err := newSignature(signature, s.Config.BlacklistedStrings, s.Config.Redaction.Salt)
+		if err != nil {
+			s.Log.Error("Skipping signature %s: %s", signature.Name, err)
+			continue
 		}
+
+		signatures = append(signatures, compiled)
 	}
 
 	return signatures
 }
+
+func lowerAll(values []string) []string {
+	lowered := make([]string, 0, len(values))
+	for _, value := range values {
+		lowered = append(lowered, strings.ToLower(value))
+	}
+
+	return lowered
+}
@@ -0,0 +1,116 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"time"
+)
+
+// slowSignatureThreshold is how long a signature may take to scan the 1MB
+// benchmark corpus before it is reported as slow. Go's regexp engine never
+// backtracks, so this catches patterns that are slow per byte (large counted
+// repetitions, huge alternations) rather than exponential ones.
+const slowSignatureThreshold = time.Second
+
+// benchmarkCorpusSize is the size of each generated benchmark input.
+const benchmarkCorpusSize = 256 * 1024
+
+// SignatureTestResult is the outcome of running one signature's embedded
+// examples and timing it against the benchmark corpus.
+type SignatureTestResult struct {
+	Name     string
+	Examples int
+	Error    error
+	Failures []string
+	Duration time.Duration
+	Slow     bool
+}
+
+func (r SignatureTestResult) Failed() bool {
+	return r.Error != nil || len(r.Failures) > 0
+}
+
+// exampleFile builds the MatchFile an example stands for: file contents for
+// content signatures, otherwise a path, file name or extension.
+func exampleFile(part string, example string) MatchFile {
+	switch part {
+	case PartContents:
+		return MatchFile{Contents: []byte(example)}
+	case PartExtension:
+		return MatchFile{Extension: example}
+	}
+
+	_, filename := filepath.Split(example)
+	return MatchFile{Path: example, Filename: filename, Extension: filepath.Ext(example)}
+}
+
+// benchmarkCorpus returns inputs that stress regular expressions in different
+// ways: long runs of one character, random alphanumerics and random printable
+// text. The same seed is used every run so timings are comparable.
+func benchmarkCorpus() [][]byte {
+	random := rand.New(rand.NewSource(1))
+	alphanumeric := []byte("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789")
+
+	randomAlphanumeric := make([]byte, benchmarkCorpusSize)
+	randomPrintable := make([]byte, benchmarkCorpusSize)
+	for i := 0; i < benchmarkCorpusSize; i++ {
+		randomAlphanumeric[i] = alphanumeric[random.Intn(len(alphanumeric))]
+		randomPrintable[i] = byte(0x20 + random.Intn(0x5f))
+	}
+
+	return [][]byte{
+		bytes.Repeat([]byte("a"), benchmarkCorpusSize),
+		bytes.Repeat([]byte("key = '"), benchmarkCorpusSize/7),
+		randomAlphanumeric,
+		randomPrintable,
+	}
+}
+
+// CheckSignatureExamples compiles every signature in config, checks it
+// against its examples_match and examples_no_match lists and times it against
+// a generated corpus to catch pathologically slow patterns.
+func CheckSignatureExamples(config *Config) []SignatureTestResult {
+	var results []SignatureTestResult
+	corpus := benchmarkCorpus()
+
+	for _, configSignature := range config.Signatures {
+		result := SignatureTestResult{
+			Name:     configSignature.Name,
+			Examples: len(configSignature.ExamplesMatch) + len(configSignature.ExamplesNoMatch),
+		}
+
+		signature, err := NewSignature(configSignature)
+		if err != nil {
+			result.Error = err
+			results = append(results, result)
+			continue
+		}
+
+		for _, example := range configSignature.ExamplesMatch {
+			if matched, _ := signature.Match(exampleFile(configSignature.Part, example)); !matched {
+				result.Failures = append(result.Failures, fmt.Sprintf("expected a match: %s", example))
+			}
+		}
+
+		for _, example := range configSignature.ExamplesNoMatch {
+			if matched, _ := signature.Match(exampleFile(configSignature.Part, example)); matched {
+				result.Failures = append(result.Failures, fmt.Sprintf("expected no match: %s", example))
+			}
+		}
+
+		if pattern, ok := signature.(PatternSignature); ok {
+			start := time.Now()
+			for _, input := range corpus {
+				pattern.match.FindAllIndex(input, -1)
+			}
+			result.Duration = time.Since(start)
+			result.Slow = result.Duration > slowSignatureThreshold
+		}
+
+		results = append(results, result)
+	}
+
+	return results
+}
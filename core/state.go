@@ -0,0 +1,170 @@
+package core
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// queueMaxLength bounds each work queue; producers drop new work while a
+	// queue is full rather than filling the disk.
+	queueMaxLength = 100000
+
+	// seenCapacity is how many GitHub event and gist IDs are remembered.
+	seenCapacity = 200000
+)
+
+// Names of the work queues, as used for their files and by the coordinator.
+const (
+	QueueRepositories  = "repositories"
+	QueueGists         = "gists"
+	QueueComments      = "comments"
+	QueueSearchResults = "search_results"
+)
+
+// QueuedSearchResult is how a SearchResult is stored in its queue, with the
+// signature referred to by name.
+type QueuedSearchResult struct {
+	Signature string `json:"signature"`
+	Url       string `json:"url"`
+	RawUrl    string `json:"raw_url"`
+}
+
+// StateDirectory returns the directory holding the queues, seen set and
+// cursors, creating it if needed.
+func (s *Session) StateDirectory() string {
+	dir := *s.Options.StateDirectory
+	if dir == "" {
+		dir = filepath.Join(s.getCsvDir(), "state")
+	}
+
+	os.MkdirAll(dir, 0700)
+	return dir
+}
+
+// InitQueues opens the durable work queues, the set of events already seen
+// and the polling cursors. Work left over from the previous run is resumed.
+func (s *Session) InitQueues() {
+	dir := s.StateDirectory()
+
+	for _, queue := range s.queues() {
+		if *queue.queue, err = OpenQueue(filepath.Join(dir, queue.name+".queue"), queueMaxLength); err != nil {
+			s.Log.Fatal("Could not open the %s queue: %s", queue.name, err)
+		}
+
+		if pending := (*queue.queue).Len(); pending > 0 {
+			s.Log.Info("Resuming %d queued %s", pending, queue.name)
+		}
+	}
+
+	if s.Seen, err = OpenSeenSet(filepath.Join(dir, "seen"), seenCapacity); err != nil {
+		s.Log.Fatal("Could not open the seen events: %s", err)
+	}
+
+	if s.Cursors, err = OpenCursors(filepath.Join(dir, "cursors.json")); err != nil {
+		s.Log.Fatal("Could not open the cursors: %s", err)
+	}
+}
+
+// queues lists the work queues in the order workers should prefer them.
+func (s *Session) queues() []struct {
+	queue **Queue
+	name  string
+} {
+	return []struct {
+		queue **Queue
+		name  string
+	}{
+		{&s.Repositories, QueueRepositories},
+		{&s.Gists, QueueGists},
+		{&s.Comments, QueueComments},
+		{&s.SearchResults, QueueSearchResults},
+	}
+}
+
+// Queue returns the work queue called name, or nil if there is none.
+func (s *Session) Queue(name string) *Queue {
+	for _, queue := range s.queues() {
+		if queue.name == name {
+			return *queue.queue
+		}
+	}
+
+	return nil
+}
+
+// CloseQueues persists and closes the queues, seen set and cursors.
+func (s *Session) CloseQueues() {
+	for _, queue := range []*Queue{s.Repositories, s.Gists, s.Comments, s.SearchResults} {
+		if queue != nil {
+			if err := queue.Close(); err != nil {
+				s.Log.Error("Could not save queue position: %s", err)
+			}
+		}
+	}
+
+	if s.Seen != nil {
+		if err := s.Seen.Save(); err != nil {
+			s.Log.Error("Could not save seen events: %s", err)
+		}
+	}
+}
+
+// Enqueue pushes value on to queue. A full queue drops the value with a
+// warning and returns the error, so callers must not mark it as seen.
+func (s *Session) Enqueue(queue *Queue, value interface{}) error {
+	err := queue.Push(value)
+	if err != nil {
+		s.Log.Warn("Dropping queued work: %s", err)
+	}
+
+	return err
+}
+
+// Ack marks an item taken from queue as handled. Items whose work was cut
+// short by the session stopping are left for the next run.
+func (s *Session) Ack(queue *Queue, ticket Ticket) {
+	if s.Context.Err() == nil {
+		queue.Done(ticket)
+	}
+}
+
+// EnqueueSearchResult queues a code search result for fetching.
+func (s *Session) EnqueueSearchResult(result SearchResult) {
+	s.Enqueue(s.SearchResults, QueuedSearchResult{
+		Signature: result.Signature.Name(),
+		Url:       result.Url,
+		RawUrl:    result.RawUrl,
+	})
+}
+
+// PopSearchResult waits for the next queued search result. Results for
+// signatures that no longer exist are discarded.
+func (s *Session) PopSearchResult(ctx context.Context) (SearchResult, Ticket, error) {
+	for {
+		var queued QueuedSearchResult
+		ticket, err := s.SearchResults.Pop(ctx, &queued)
+		if err != nil {
+			return SearchResult{}, 0, err
+		}
+
+		if result, ok := s.ResolveSearchResult(queued); ok {
+			return result, ticket, nil
+		}
+
+		s.SearchResults.Done(ticket)
+	}
+}
+
+// ResolveSearchResult looks up the signature of a queued search result by
+// name, returning false if it no longer exists.
+func (s *Session) ResolveSearchResult(queued QueuedSearchResult) (SearchResult, bool) {
+	for _, signature := range s.CurrentSignatures() {
+		if signature.Name() == queued.Signature {
+			return SearchResult{Signature: signature, Url: queued.Url, RawUrl: queued.RawUrl}, true
+		}
+	}
+
+	return SearchResult{}, false
+}
@@ -0,0 +1,221 @@
+package core
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stats holds counters about the running pipeline. The counters are updated
+// atomically and the breakdowns under a lock, so they can be read from the UI
+// and the metrics endpoint while workers are running.
+type Stats struct {
+	EventsPolled         uint64
+	GistsPolled          uint64
+	ReposCloned          uint64
+	ReposFailed          uint64
+	FilesScanned         uint64
+	BytesScanned         uint64
+	BinaryFilesSkipped   uint64
+	FilteredFilesSkipped uint64
+	Findings             uint64
+	BaselinedFindings    uint64
+
+	lock        sync.Mutex
+	findings    map[FindingLabels]uint64
+	sources     map[string]uint64
+	validations map[ValidationLabels]uint64
+	rateLimits  map[string]RateLimit
+}
+
+// FindingLabels breaks down findings by signature and relevance.
+type FindingLabels struct {
+	Signature string
+	Relevance string
+}
+
+// ValidationLabels breaks down validator calls by signature and outcome.
+type ValidationLabels struct {
+	Signature string
+	Valid     bool
+}
+
+// RateLimit is the GitHub API rate limit last reported for a token.
+type RateLimit struct {
+	Limit     int
+	Remaining int
+	Reset     time.Time
+}
+
+func (s *Stats) AddEventsPolled(n int) {
+	atomic.AddUint64(&s.EventsPolled, uint64(n))
+}
+
+func (s *Stats) GetEventsPolled() uint64 {
+	return atomic.LoadUint64(&s.EventsPolled)
+}
+
+func (s *Stats) AddGistsPolled(n int) {
+	atomic.AddUint64(&s.GistsPolled, uint64(n))
+}
+
+func (s *Stats) GetGistsPolled() uint64 {
+	return atomic.LoadUint64(&s.GistsPolled)
+}
+
+func (s *Stats) IncReposCloned() {
+	atomic.AddUint64(&s.ReposCloned, 1)
+}
+
+func (s *Stats) GetReposCloned() uint64 {
+	return atomic.LoadUint64(&s.ReposCloned)
+}
+
+func (s *Stats) IncReposFailed() {
+	atomic.AddUint64(&s.ReposFailed, 1)
+}
+
+func (s *Stats) GetReposFailed() uint64 {
+	return atomic.LoadUint64(&s.ReposFailed)
+}
+
+// AddFileScanned counts a file of size bytes that signatures were run on.
+func (s *Stats) AddFileScanned(size int) {
+	atomic.AddUint64(&s.FilesScanned, 1)
+	atomic.AddUint64(&s.BytesScanned, uint64(size))
+}
+
+func (s *Stats) GetFilesScanned() uint64 {
+	return atomic.LoadUint64(&s.FilesScanned)
+}
+
+func (s *Stats) GetBytesScanned() uint64 {
+	return atomic.LoadUint64(&s.BytesScanned)
+}
+
+func (s *Stats) IncBinaryFilesSkipped() {
+	atomic.AddUint64(&s.BinaryFilesSkipped, 1)
+}
+
+func (s *Stats) GetBinaryFilesSkipped() uint64 {
+	return atomic.LoadUint64(&s.BinaryFilesSkipped)
+}
+
+// IncFilteredFilesSkipped counts a file skipped for its size, extension or
+// path.
+func (s *Stats) IncFilteredFilesSkipped() {
+	atomic.AddUint64(&s.FilteredFilesSkipped, 1)
+}
+
+func (s *Stats) GetFilteredFilesSkipped() uint64 {
+	return atomic.LoadUint64(&s.FilteredFilesSkipped)
+}
+
+func (s *Stats) IncFindings() {
+	atomic.AddUint64(&s.Findings, 1)
+}
+
+func (s *Stats) GetFindings() uint64 {
+	return atomic.LoadUint64(&s.Findings)
+}
+
+// RecordFinding counts a reported finding, in total, by its signature and
+// relevance and by its source.
+func (s *Stats) RecordFinding(event *MatchEvent) {
+	s.IncFindings()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.findings == nil {
+		s.findings = make(map[FindingLabels]uint64)
+		s.sources = make(map[string]uint64)
+	}
+	s.findings[FindingLabels{Signature: event.Signature, Relevance: event.Relevance.String()}]++
+	s.sources[event.Source.String()]++
+}
+
+// GetFindingsByLabels returns a copy of the findings counted by signature
+// and relevance.
+func (s *Stats) GetFindingsByLabels() map[FindingLabels]uint64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	findings := make(map[FindingLabels]uint64, len(s.findings))
+	for labels, count := range s.findings {
+		findings[labels] = count
+	}
+
+	return findings
+}
+
+// GetFindingsBySource returns a copy of the findings counted by source.
+func (s *Stats) GetFindingsBySource() map[string]uint64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	sources := make(map[string]uint64, len(s.sources))
+	for source, count := range s.sources {
+		sources[source] = count
+	}
+
+	return sources
+}
+
+func (s *Stats) IncBaselinedFindings() {
+	atomic.AddUint64(&s.BaselinedFindings, 1)
+}
+
+func (s *Stats) GetBaselinedFindings() uint64 {
+	return atomic.LoadUint64(&s.BaselinedFindings)
+}
+
+// RecordValidation counts a validator call for signature and its outcome.
+func (s *Stats) RecordValidation(signature string, valid bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.validations == nil {
+		s.validations = make(map[ValidationLabels]uint64)
+	}
+	s.validations[ValidationLabels{Signature: signature, Valid: valid}]++
+}
+
+// GetValidations returns a copy of the validator calls counted by signature
+// and outcome.
+func (s *Stats) GetValidations() map[ValidationLabels]uint64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	validations := make(map[ValidationLabels]uint64, len(s.validations))
+	for labels, count := range s.validations {
+		validations[labels] = count
+	}
+
+	return validations
+}
+
+// SetRateLimit records the rate limit GitHub last reported for token, which
+// is a redacted name for it rather than the token itself.
+func (s *Stats) SetRateLimit(token string, limit RateLimit) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.rateLimits == nil {
+		s.rateLimits = make(map[string]RateLimit)
+	}
+	s.rateLimits[token] = limit
+}
+
+// GetRateLimits returns a copy of the last rate limit seen for each token.
+func (s *Stats) GetRateLimits() map[string]RateLimit {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	rateLimits := make(map[string]RateLimit, len(s.rateLimits))
+	for token, limit := range s.rateLimits {
+		rateLimits[token] = limit
+	}
+
+	return rateLimits
+}
@@ -0,0 +1,197 @@
+package core
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Triage statuses an analyst can give a finding in the UI.
+const (
+	TriageTruePositive  = "true-positive"
+	TriageFalsePositive = "false-positive"
+	TriageRevoked       = "revoked"
+	TriageIgnored       = "ignored"
+)
+
+// TriageAllowlistFile is written to the signature packs directory with the
+// allowlist rules added when findings are marked as false positives.
+const TriageAllowlistFile = "triage.yaml"
+
+// IsResolved reports whether a finding with status needs no further action.
+// True positives are not resolved until they are revoked.
+func IsResolved(status string) bool {
+	return status == TriageFalsePositive || status == TriageRevoked || status == TriageIgnored
+}
+
+// Triage holds the status given to findings, by fingerprint, so that it
+// survives a restart.
+type Triage struct {
+	sync.Mutex `yaml:"-"`
+
+	path     string
+	salt     string
+	Findings []TriageEntry `yaml:"findings"`
+	index    map[string]int
+}
+
+type TriageEntry struct {
+	Fingerprint string `yaml:"fingerprint"`
+	Signature   string `yaml:"signature"`
+	Status      string `yaml:"status"`
+	Url         string `yaml:"url,omitempty"`
+	File        string `yaml:"file,omitempty"`
+	Line        int    `yaml:"line,omitempty"`
+	Updated     string `yaml:"updated"`
+}
+
+// LoadTriage reads the triage file at path, whose fingerprints are keyed with
+// salt. A missing file yields an empty triage that will be created on the
+// first change.
+func LoadTriage(path string, salt string) (*Triage, error) {
+	triage := &Triage{path: path, salt: salt, index: make(map[string]int)}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return triage, nil
+	} else if err != nil {
+		return triage, err
+	}
+
+	if err := yaml.Unmarshal(data, triage); err != nil {
+		return triage, fmt.Errorf("invalid triage file %s: %s", path, err)
+	}
+
+	for i, entry := range triage.Findings {
+		triage.index[entry.Fingerprint] = i
+	}
+
+	return triage, nil
+}
+
+// Status returns the status given to event, or an empty string if it has not
+// been triaged. It is safe to call on a nil triage.
+func (t *Triage) Status(event *MatchEvent) string {
+	if t == nil {
+		return ""
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	if i, exists := t.index[event.Fingerprint(t.salt)]; exists {
+		return t.Findings[i].Status
+	}
+
+	return ""
+}
+
+// Set gives event status, or clears its status if status is empty, and saves
+// the triage file.
+func (t *Triage) Set(event *MatchEvent, status string) error {
+	t.Lock()
+	defer t.Unlock()
+
+	fingerprint := event.Fingerprint(t.salt)
+	if i, exists := t.index[fingerprint]; exists {
+		t.Findings = append(t.Findings[:i], t.Findings[i+1:]...)
+		t.index = make(map[string]int, len(t.Findings))
+		for j, entry := range t.Findings {
+			t.index[entry.Fingerprint] = j
+		}
+	}
+
+	if status != "" {
+		t.index[fingerprint] = len(t.Findings)
+		t.Findings = append(t.Findings, TriageEntry{
+			Fingerprint: fingerprint,
+			Signature:   event.Signature,
+			Status:      status,
+			Url:         event.Url,
+			File:        event.File,
+			Line:        event.Line,
+			Updated:     time.Now().UTC().Format(time.RFC3339),
+		})
+	}
+
+	data, err := yaml.Marshal(t)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(t.path, data)
+}
+
+// InitTriage loads the triage statuses from the state directory.
+func (s *Session) InitTriage() {
+	if s.Triage, err = LoadTriage(filepath.Join(s.StateDirectory(), "triage.yaml"), s.Config.Redaction.Salt); err != nil {
+		s.Log.Fatal("Could not load triage: %s", err)
+	}
+}
+
+// HasSignatureConfig reports whether name is a configured signature, which
+// an allowlist rule can be added to.
+func (s *Session) HasSignatureConfig(name string) bool {
+	for _, signature := range s.CurrentConfig().Signatures {
+		if signature.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AllowlistSecret adds a rule allowing event's exact match to its signature.
+// The rule holds the salted hash of the match, never the match itself.
+func (s *Session) AllowlistSecret(event *MatchEvent) (string, error) {
+	return s.appendAllowlistRule(ConfigAllowlistRule{
+		Signature: event.Signature,
+		Secrets:   []string{SecretHash(s.CurrentConfig().Redaction.Salt, event.Match)},
+	})
+}
+
+// AllowlistPath adds a rule allowing event's file path to its signature, in
+// any repository.
+func (s *Session) AllowlistPath(event *MatchEvent) (string, error) {
+	return s.appendAllowlistRule(ConfigAllowlistRule{
+		Signature: event.Signature,
+		Paths:     []string{"^" + regexp.QuoteMeta(event.File) + "$"},
+	})
+}
+
+// appendAllowlistRule appends rule to the triage allowlist file next to the
+// configuration, which the config reload then applies. It returns the file
+// written.
+func (s *Session) appendAllowlistRule(rule ConfigAllowlistRule) (string, error) {
+	dir := filepath.Join(filepath.Dir(s.CurrentConfig().Path), SignaturePacksDir)
+	path := filepath.Join(dir, TriageAllowlistFile)
+
+	var file struct {
+		Allowlists []ConfigAllowlistRule `yaml:"allowlists"`
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return path, err
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return path, fmt.Errorf("invalid allowlist file %s: %s", path, err)
+	}
+
+	file.Allowlists = append(file.Allowlists, rule)
+	if data, err = yaml.Marshal(&file); err != nil {
+		return path, err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return path, err
+	}
+
+	return path, writeFileAtomic(path, data)
+}
@@ -10,17 +10,31 @@ import (
 
 type UI struct {
 	App              *tview.Application
+	Pages            *tview.Pages
 	MainWindow       *tview.Flex
 	StatusWindow     *tview.TextView
+	SearchBar        *tview.InputField
 	SignaturesWindow *tview.List
 	DetailsWindow    *tview.Table
+	StatsWindow      *tview.TextView
+	ContentWindow    *tview.Flex
 	LogWindow        *tview.TextView
 }
 
 var tui UI
+
+// The findings and view state are only read and written on the UI goroutine,
+// from input handlers and queued updates.
 var signatures map[string][]MatchEvent
+var signatureOrder []string
+var listedSignatures map[string]bool
+var publishedEvents map[string]time.Time
+var publishQueue = make(chan *MatchEvent, 256)
+var searchReturnFocus tview.Primitive
 var lastSelectedRow = 1
 var hideLowRelevance = false
+var showResolved = false
+var showStats = false
 
 func GetUI() *UI {
 	return &tui
@@ -28,6 +42,8 @@ func GetUI() *UI {
 
 func (ui *UI) Initialize() {
 	signatures = make(map[string][]MatchEvent)
+	listedSignatures = make(map[string]bool)
+	publishedEvents = make(map[string]time.Time)
 
 	ui.App = tview.NewApplication()
 
@@ -40,6 +56,11 @@ func (ui *UI) Initialize() {
 		ui.App.Draw()
 	})
 
+	ui.SearchBar = tview.NewInputField()
+	ui.SearchBar.SetLabel("[::b]Filter: ")
+	ui.SearchBar.SetPlaceholder("text, /regex/, source:gist, relevance:high, since:24h, until:2006-01-02")
+	ui.SearchBar.SetDoneFunc(ui.closeSearch)
+
 	ui.LogWindow = tview.NewTextView()
 	ui.LogWindow.SetBorder(true)
 	ui.LogWindow.SetTitle("[::b]Log")
@@ -70,7 +91,7 @@ func (ui *UI) Initialize() {
 	ui.DetailsWindow.Select(1, 0)
 	ui.DetailsWindow.SetBorders(true).
 		SetBorder(true).
-		SetTitle("[::b]Details")
+		SetTitle("[::b]Details[::-] ([::bu]t[::-]rue positive, [::bu]f[::-]alse positive, revo[::bu]k[::-]ed, [::bu]i[::-]gnored, [::bu]u[::-]ntriaged, s[::bu]o[::-]rt)")
 	ui.DetailsWindow.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
 		if event.Key() == tcell.KeyLeft {
 			r, c := ui.DetailsWindow.GetSelection()
@@ -80,41 +101,96 @@ func (ui *UI) Initialize() {
 				return nil
 			}
 		}
+
+		if status, exists := triageKeys[event.Rune()]; exists {
+			if selected := ui.selectedEvent(); selected != nil {
+				if status == TriageFalsePositive {
+					ui.confirmFalsePositive(selected)
+				} else {
+					ui.triage(selected, status)
+				}
+			}
+			return nil
+		}
+
+		if event.Rune() == 'o' {
+			ui.sortBySelectedColumn()
+			return nil
+		}
 		return sharedInput(event)
 	})
+	ui.DetailsWindow.SetSelectedFunc(func(row, column int) {
+		if selected := ui.selectedEvent(); selected != nil {
+			ui.showDetail(selected)
+		}
+	})
+
+	ui.StatsWindow = tview.NewTextView()
+	ui.StatsWindow.SetBorder(true)
+	ui.StatsWindow.SetTitle("[::b]Stats")
+	ui.StatsWindow.SetDynamicColors(true)
 
-	hflex := tview.NewFlex().SetDirection(tview.FlexColumn).
+	ui.ContentWindow = tview.NewFlex().SetDirection(tview.FlexColumn).
 		AddItem(ui.SignaturesWindow, 0, 1, false).
 		AddItem(ui.DetailsWindow, 0, 6, false)
 
 	ui.MainWindow = tview.NewFlex().SetDirection(tview.FlexRow)
 	ui.MainWindow.AddItem(ui.StatusWindow, 3, 1, false)
-	ui.MainWindow.AddItem(hflex, 0, 1, false)
+	ui.MainWindow.AddItem(ui.SearchBar, 0, 0, false)
+	ui.MainWindow.AddItem(ui.ContentWindow, 0, 1, false)
 	ui.MainWindow.AddItem(ui.LogWindow, 10, 1, false)
 
+	ui.Pages = tview.NewPages().AddPage("main", ui.MainWindow, true, true)
+
 	go ui.UpdateStatus()
+	go ui.publishQueued()
 }
 
 func (ui *UI) AddToDetailsWindow(signature string, event *MatchEvent) {
-	event.AdditionalInfo["URL"] = event.Url
 	if hideLowRelevance && event.Relevance == RelevanceLow {
 		return
 	}
 
+	status := session.Triage.Status(event)
+	if !showResolved && IsResolved(status) {
+		return
+	}
+
+	if !activeFilter.Matches(event, publishedEvents[session.Fingerprint(event)]) {
+		return
+	}
+
 	selectedSignature, _ := ui.SignaturesWindow.GetItemText(ui.SignaturesWindow.GetCurrentItem())
 	if selectedSignature == signature {
 		idx := ui.DetailsWindow.GetRowCount()
 		columns := session.GetView(signature)
+		row := *event
+		redaction := session.CurrentConfig().Redaction
 
 		for i, column := range columns {
-			value, exists := event.AdditionalInfo[column]
+			value, exists := event.GetColumn(column, redaction)
 			textColor := ui.relevanceToColor(event.Relevance)
-			if exists {
-				ui.DetailsWindow.SetCell(idx, i, tview.NewTableCell(value).SetTextColor(textColor))
-			} else {
-				ui.DetailsWindow.SetCell(idx, i, tview.NewTableCell("???").SetTextColor(textColor))
+			if column == "Severity" {
+				textColor = ui.severityToColor(event.Severity)
 			}
+			if !exists {
+				value = "???"
+			}
+			ui.DetailsWindow.SetCell(idx, i, tview.NewTableCell(value).SetTextColor(textColor).SetReference(&row))
 		}
+
+		ui.DetailsWindow.SetCell(idx, len(columns), tview.NewTableCell(status).SetTextColor(ui.statusToColor(status)).SetReference(&row))
+	}
+}
+
+func (ui *UI) statusToColor(status string) tcell.Color {
+	switch status {
+	case TriageTruePositive:
+		return tcell.ColorRed
+	case TriageRevoked:
+		return tcell.ColorGreen
+	default:
+		return tcell.ColorGray
 	}
 }
 
@@ -128,32 +204,80 @@ func (ui *UI) relevanceToColor(relevance Relevance) tcell.Color {
 	}
 }
 
-func (ui *UI) Publish(event *MatchEvent) {
-	results, contains := signatures[event.Signature]
+func (ui *UI) severityToColor(severity string) tcell.Color {
+	switch SeverityRank(severity) {
+	case severityRanks[SeverityCritical]:
+		return tcell.ColorRed
+	case severityRanks[SeverityHigh]:
+		return tcell.ColorOrange
+	case severityRanks[SeverityMedium]:
+		return tcell.ColorYellow
+	default:
+		return tcell.ColorGray
+	}
+}
 
-	if !contains {
-		results = []MatchEvent{}
-		ui.SignaturesWindow.AddItem(event.Signature, "", 0, nil)
+// Publish adds event to the UI. It is safe to call from any goroutine: the
+// event is handed to the UI goroutine, which owns the findings. It gives up
+// once the session stops, when the UI may no longer be running.
+func (ui *UI) Publish(event *MatchEvent) {
+	select {
+	case publishQueue <- event:
+	case <-session.Context.Done():
 	}
+}
 
-	duplicate := false
-	for _, r := range results {
-		if r.Url == event.Url && r.Match == event.Match {
-			duplicate = true
-			break
+// publishQueued adds the queued events to the UI on the UI goroutine, as many
+// at a time as have been queued, redrawing once for each batch.
+func (ui *UI) publishQueued() {
+	for event := range publishQueue {
+		batch := []*MatchEvent{event}
+		for len(publishQueue) > 0 {
+			batch = append(batch, <-publishQueue)
 		}
+
+		ui.App.QueueUpdateDraw(func() {
+			for _, event := range batch {
+				ui.publish(event)
+			}
+		})
+	}
+}
+
+func (ui *UI) publish(event *MatchEvent) {
+	if _, contains := signatures[event.Signature]; !contains {
+		signatures[event.Signature] = []MatchEvent{}
+		signatureOrder = append(signatureOrder, event.Signature)
+	}
+
+	fingerprint := session.Fingerprint(event)
+	if _, published := publishedEvents[fingerprint]; published {
+		return
 	}
 
-	if !duplicate {
-		signatures[event.Signature] = append(results, *event)
+	publishedEvents[fingerprint] = time.Now()
+	if !listedSignatures[event.Signature] && activeFilter.Matches(event, publishedEvents[fingerprint]) {
+		listedSignatures[event.Signature] = true
+		ui.SignaturesWindow.AddItem(event.Signature, "", 0, nil)
+	}
+
+	signatures[event.Signature] = append(signatures[event.Signature], *event)
+	if sortColumn == "" {
 		ui.AddToDetailsWindow(event.Signature, event)
+	} else if selected, _ := ui.SignaturesWindow.GetItemText(ui.SignaturesWindow.GetCurrentItem()); selected == event.Signature {
+		ui.redrawDetailsWindow(event.Signature)
 	}
 }
 
 func (ui *UI) Run() {
-	if err := ui.App.SetRoot(ui.MainWindow, true).SetFocus(ui.SignaturesWindow).Run(); err != nil {
+	if err := ui.App.SetRoot(ui.Pages, true).SetFocus(ui.SignaturesWindow).Run(); err != nil {
 		panic(err)
 	}
+
+	// log to the terminal again while shutting down
+	session.Log.Lock()
+	ui.LogWindow = nil
+	session.Log.Unlock()
 }
 
 var spinner = []string{"⣾", "⣽", "⣻", "⢿", "⡿", "⣟", "⣯", "⣷"}
@@ -164,7 +288,11 @@ func (ui *UI) UpdateStatus() {
 	for {
 		time.Sleep(refreshInterval)
 		ui.App.QueueUpdateDraw(func() {
+			recordCloneSample(time.Now())
 			ui.StatusWindow.SetText(GetUpdateString())
+			if showStats {
+				ui.StatsWindow.SetText(GetStatsString())
+			}
 			spinnerCounter += 1
 		})
 	}
@@ -174,29 +302,125 @@ func getSpinnerCharacter() string {
 	return spinner[7-spinnerCounter%8]
 }
 
-func GetUpdateString() string {
-	hideLowRelevanceText := "✕"
-	if hideLowRelevance {
-		hideLowRelevanceText = "✓"
+func (ui *UI) redrawDetailsWindow(signature string) {
+	ui.DetailsWindow.Clear()
+
+	columns := detailsColumns(signature)
+	for i, c := range columns {
+		header := fmt.Sprintf("[::b]%s", c)
+		if c == sortColumn && sortDescending {
+			header += " ▼"
+		} else if c == sortColumn {
+			header += " ▲"
+		}
+		ui.DetailsWindow.SetCell(0, i, tview.NewTableCell(header))
+	}
+
+	events := make([]*MatchEvent, 0, len(signatures[signature]))
+	for i := range signatures[signature] {
+		events = append(events, &signatures[signature][i])
 	}
+	sortFindings(events)
 
-	return fmt.Sprintf("[#00FFFF]%s [::l]Running[::-] %s Signatures: %d | [::bu]H[::-]ide low relevance: %s | [::bu]Q[::-]uit",
-		getSpinnerCharacter(),
-		getSpinnerCharacter(),
-		len(session.Signatures),
-		hideLowRelevanceText)
+	for _, event := range events {
+		ui.AddToDetailsWindow(signature, event)
+	}
 }
 
-func (ui *UI) redrawDetailsWindow(signature string) {
+// detailsColumns returns the Details columns for signature: its view and the
+// triage status.
+func detailsColumns(signature string) []string {
+	view := session.GetView(signature)
+	return append(append(make([]string, 0, len(view)+1), view...), "Status")
+}
+
+// redrawSignaturesWindow lists the signatures with findings that pass the
+// filter, keeping the selected signature selected if it is still listed.
+func (ui *UI) redrawSignaturesWindow() {
+	selected := ""
+	if ui.SignaturesWindow.GetItemCount() > 0 {
+		selected, _ = ui.SignaturesWindow.GetItemText(ui.SignaturesWindow.GetCurrentItem())
+	}
+
+	ui.SignaturesWindow.Clear()
 	ui.DetailsWindow.Clear()
+	listedSignatures = make(map[string]bool)
+
+	for _, signature := range signatureOrder {
+		for i := range signatures[signature] {
+			event := &signatures[signature][i]
+			if activeFilter.Matches(event, publishedEvents[session.Fingerprint(event)]) {
+				listedSignatures[signature] = true
+				ui.SignaturesWindow.AddItem(signature, "", 0, nil)
+				break
+			}
+		}
+	}
 
-	columns := session.GetView(signature)
-	for i, c := range columns {
-		ui.DetailsWindow.SetCell(0, i, tview.NewTableCell(fmt.Sprintf("[::b]%s", c)))
+	for index := 0; index < ui.SignaturesWindow.GetItemCount(); index++ {
+		if name, _ := ui.SignaturesWindow.GetItemText(index); name == selected {
+			ui.SignaturesWindow.SetCurrentItem(index)
+			break
+		}
+	}
+}
+
+// openSearch shows the filter bar and moves the focus to it.
+func (ui *UI) openSearch() {
+	searchReturnFocus = ui.App.GetFocus()
+	ui.MainWindow.ResizeItem(ui.SearchBar, 1, 0)
+	ui.App.SetFocus(ui.SearchBar)
+}
+
+// closeSearch applies the filter on Enter, or restores the one in use on
+// Escape. The bar stays visible while a filter is applied.
+func (ui *UI) closeSearch(key tcell.Key) {
+	switch key {
+	case tcell.KeyEnter:
+		filter, err := parseFindingFilter(ui.SearchBar.GetText(), time.Now())
+		if err != nil {
+			session.Log.Warn("Invalid filter: %s", err)
+			return
+		}
+		activeFilter = filter
+		ui.redrawSignaturesWindow()
+	case tcell.KeyEscape:
+		if activeFilter != nil {
+			ui.SearchBar.SetText(activeFilter.query)
+		} else {
+			ui.SearchBar.SetText("")
+		}
+	default:
+		return
+	}
+
+	if activeFilter == nil {
+		ui.MainWindow.ResizeItem(ui.SearchBar, 0, 0)
+	}
+	ui.App.SetFocus(searchReturnFocus)
+}
+
+// sortBySelectedColumn sorts the details by the column of the selected cell,
+// cycling through ascending, descending and unsorted.
+func (ui *UI) sortBySelectedColumn() {
+	if ui.SignaturesWindow.GetItemCount() == 0 {
+		return
+	}
+
+	signature, _ := ui.SignaturesWindow.GetItemText(ui.SignaturesWindow.GetCurrentItem())
+	columns := detailsColumns(signature)
+	if _, column := ui.DetailsWindow.GetSelection(); column < len(columns) {
+		cycleSort(columns[column])
+		ui.redrawDetailsWindow(signature)
 	}
+}
 
-	for _, r := range signatures[signature] {
-		ui.AddToDetailsWindow(signature, &r)
+// redrawSelectedSignature redraws the details of the selected signature, if
+// there is one.
+func (ui *UI) redrawSelectedSignature() {
+	if ui.SignaturesWindow.GetItemCount() > 0 {
+		mainText, _ := ui.SignaturesWindow.GetItemText(ui.SignaturesWindow.GetCurrentItem())
+		ui.redrawDetailsWindow(mainText)
 	}
 }
 
@@ -205,14 +429,34 @@ func sharedInput(event *tcell.EventKey) *tcell.EventKey {
 		ui := GetUI()
 		hideLowRelevance = !hideLowRelevance
 		ui.StatusWindow.SetText(GetUpdateString())
-
-		if ui.SignaturesWindow.GetItemCount() > 0 {
-			mainText, _ := ui.SignaturesWindow.GetItemText(ui.SignaturesWindow.GetCurrentItem())
-			ui.redrawDetailsWindow(mainText)
-		}
+		ui.redrawSelectedSignature()
+		return nil
+	} else if event.Rune() == 'r' {
+		ui := GetUI()
+		showResolved = !showResolved
+		ui.StatusWindow.SetText(GetUpdateString())
+		ui.redrawSelectedSignature()
+		return nil
+	} else if event.Rune() == '/' {
+		GetUI().openSearch()
+		return nil
+	} else if event.Rune() == 's' {
+		GetUI().toggleStats()
 		return nil
 	} else if event.Rune() == 'q' {
 		GetUI().App.Stop()
 	}
 	return event
 }
+
+// toggleStats shows or hides the stats panel to the right of the details.
+func (ui *UI) toggleStats() {
+	showStats = !showStats
+
+	if showStats {
+		ui.StatsWindow.SetText(GetStatsString())
+		ui.ContentWindow.AddItem(ui.StatsWindow, 36, 0, false)
+	} else {
+		ui.ContentWindow.RemoveItem(ui.StatsWindow)
+	}
+}
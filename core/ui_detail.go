@@ -0,0 +1,180 @@
+package core
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// detailContextLines is how many lines either side of a match the detail
+// pane shows.
+const detailContextLines = 5
+
+// showDetail opens a pane over the UI with everything known about event and
+// the lines around it. Escape or Enter closes it again.
+func (ui *UI) showDetail(event *MatchEvent) {
+	detail := tview.NewTextView()
+	detail.SetBorder(true)
+	detail.SetTitle(fmt.Sprintf("[::b]%s[::-] (Esc to close)", tview.Escape(event.Signature)))
+	detail.SetDynamicColors(true)
+	detail.SetWrap(true)
+	detail.SetText(detailString(event))
+	detail.SetInputCapture(func(key *tcell.EventKey) *tcell.EventKey {
+		if key.Key() == tcell.KeyEscape || key.Key() == tcell.KeyEnter {
+			ui.Pages.RemovePage("detail")
+			ui.App.SetFocus(ui.DetailsWindow)
+			return nil
+		}
+		return key
+	})
+
+	ui.Pages.AddPage("detail", detail, true, true)
+	ui.App.SetFocus(detail)
+}
+
+// detailString renders the detail pane: the finding, its signature, the
+// validator's additional info and the file context, with the secret redacted.
+func detailString(event *MatchEvent) string {
+	var b strings.Builder
+
+	section := func(title string) {
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "[#00FFFF::b]%s[-::-]\n", title)
+	}
+	row := func(name string, value string) {
+		if value != "" {
+			fmt.Fprintf(&b, " %-14s %s\n", name, tview.Escape(value))
+		}
+	}
+
+	section("Finding")
+	row("Source", event.Source.String())
+	row("Repository", event.Url)
+	if event.Permalink != event.Url {
+		row("URL", event.Permalink)
+	}
+	row("File", event.File)
+	if event.Line > 0 {
+		row("Line", fmt.Sprintf("%d, column %d", event.Line, event.Column))
+	}
+	if event.Stars > 0 {
+		row("Stars", strconv.Itoa(event.Stars))
+	}
+	row("Part", event.Part)
+	if event.Match != "" {
+		row("Match", session.Redact(event.Match))
+	}
+	row("Decoding", event.Decoding)
+	row("Relevance", event.Relevance.String())
+	row("Status", session.Triage.Status(event))
+	row("Fingerprint", session.Fingerprint(event))
+
+	section("Signature")
+	row("Name", event.Signature)
+	row("Severity", event.Severity)
+	row("Confidence", event.Confidence)
+	row("Tags", strings.Join(event.Tags, ", "))
+	row("Description", event.Description)
+	row("Remediation", event.Remediation)
+
+	if len(event.AdditionalInfo) > 0 {
+		section("Additional info")
+		names := make([]string, 0, len(event.AdditionalInfo))
+		for name := range event.AdditionalInfo {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			row(name, event.AdditionalInfo[name])
+		}
+	}
+
+	section("Context")
+	b.WriteString(contextString(event))
+
+	return b.String()
+}
+
+// contextString renders the lines around event's match from the file it was
+// found in, with the match redacted and highlighted.
+func contextString(event *MatchEvent) string {
+	if event.Line <= 0 {
+		return " No context for a match on the file's name or path.\n"
+	}
+
+	if event.LocalPath == "" {
+		return " Not available: the file was not kept on this machine.\n"
+	}
+
+	contents, err := ioutil.ReadFile(event.LocalPath)
+	if err != nil {
+		return fmt.Sprintf(" Not available: %s\n", tview.Escape(err.Error()))
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(contents), "\n"), "\n")
+	if event.Line > len(lines) {
+		return " Not available: the file has changed since it was scanned.\n"
+	}
+
+	first, last := event.Line-detailContextLines, event.Line+detailContextLines
+	if first < 1 {
+		first = 1
+	}
+	if last > len(lines) {
+		last = len(lines)
+	}
+
+	width := len(strconv.Itoa(last))
+	var b strings.Builder
+	for number := first; number <= last; number++ {
+		line := strings.TrimRight(lines[number-1], "\r")
+		if number != event.Line {
+			fmt.Fprintf(&b, "  [#808080]%*d[-] %s\n", width, number, redactMatch(line, event))
+			continue
+		}
+
+		fmt.Fprintf(&b, "[#FF8700]>[-] [::b]%*d[::-] %s\n", width, number, highlightMatch(line, event))
+	}
+
+	return b.String()
+}
+
+// highlightMatch replaces the match on line with its redacted form and
+// highlights it. Matches decoded from an encoded run are not found on the
+// line as written, so the line is shown as it is.
+func highlightMatch(line string, event *MatchEvent) string {
+	if event.Match == "" {
+		return redactMatch(line, event)
+	}
+
+	start := -1
+	if event.Column > 0 && event.Column-1+len(event.Match) <= len(line) && line[event.Column-1:event.Column-1+len(event.Match)] == event.Match {
+		start = event.Column - 1
+	} else {
+		start = strings.Index(line, event.Match)
+	}
+
+	if start < 0 {
+		return redactMatch(line, event)
+	}
+
+	return redactMatch(line[:start], event) +
+		"[black:yellow]" + tview.Escape(session.Redact(event.Match)) + "[-:-]" +
+		redactMatch(line[start+len(event.Match):], event)
+}
+
+// redactMatch escapes text for display with every copy of the match redacted.
+func redactMatch(text string, event *MatchEvent) string {
+	if event.Match != "" {
+		text = strings.ReplaceAll(text, event.Match, session.Redact(event.Match))
+	}
+
+	return tview.Escape(text)
+}
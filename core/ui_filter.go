@@ -0,0 +1,217 @@
+package core
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// findingFilter narrows the findings shown in the UI. Every free text term
+// must be found, case insensitively, in the repository URL, file, match or
+// validator info of a finding, and /terms/ are regexes matched against the
+// same fields. source:, relevance:, since: and until: filter on the rest.
+type findingFilter struct {
+	query      string
+	terms      []string
+	patterns   []*regexp.Regexp
+	sources    map[string]bool
+	relevances map[string]bool
+	since      time.Time
+	until      time.Time
+}
+
+// activeFilter is the filter applied to the UI, or nil to show everything.
+var activeFilter *findingFilter
+
+// parseFindingFilter parses query, with dates relative to now. An empty query
+// yields a nil filter.
+func parseFindingFilter(query string, now time.Time) (*findingFilter, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil, nil
+	}
+
+	filter := &findingFilter{query: query}
+	for _, term := range strings.Fields(query) {
+		if len(term) > 2 && strings.HasPrefix(term, "/") && strings.HasSuffix(term, "/") {
+			pattern, err := regexp.Compile(term[1 : len(term)-1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid regex %s: %s", term, err)
+			}
+			filter.patterns = append(filter.patterns, pattern)
+			continue
+		}
+
+		parts := strings.SplitN(term, ":", 2)
+		if len(parts) == 2 && parts[1] != "" {
+			switch key, value := strings.ToLower(parts[0]), strings.ToLower(parts[1]); key {
+			case "source":
+				filter.sources = addValues(filter.sources, value)
+				continue
+			case "relevance":
+				filter.relevances = addValues(filter.relevances, value)
+				continue
+			case "since", "until":
+				at, err := parseFilterTime(value, now)
+				if err != nil {
+					return nil, fmt.Errorf("invalid %s: %s", key, err)
+				}
+				if key == "since" {
+					filter.since = at
+				} else {
+					filter.until = at
+				}
+				continue
+			}
+		}
+
+		filter.terms = append(filter.terms, strings.ToLower(term))
+	}
+
+	return filter, nil
+}
+
+// addValues adds the comma separated values to set, creating it if needed.
+func addValues(set map[string]bool, values string) map[string]bool {
+	if set == nil {
+		set = make(map[string]bool)
+	}
+
+	for _, value := range strings.Split(values, ",") {
+		set[value] = true
+	}
+
+	return set
+}
+
+// parseFilterTime reads a time either as an age, such as 30m, 12h or 7d, or
+// as a local date or date and time.
+func parseFilterTime(value string, now time.Time) (time.Time, error) {
+	if strings.HasSuffix(value, "d") {
+		if days, err := strconv.Atoi(strings.TrimSuffix(value, "d")); err == nil {
+			return now.AddDate(0, 0, -days), nil
+		}
+	}
+
+	if age, err := time.ParseDuration(value); err == nil {
+		return now.Add(-age), nil
+	}
+
+	for _, layout := range []string{"2006-01-02", "2006-01-02t15:04"} {
+		if at, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return at, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("%q is neither an age such as 12h or 7d nor a date such as 2006-01-02", value)
+}
+
+// Matches reports whether event, first seen at found, passes the filter. A
+// nil filter matches everything.
+func (f *findingFilter) Matches(event *MatchEvent, found time.Time) bool {
+	if f == nil {
+		return true
+	}
+
+	if f.sources != nil && !f.sources[event.Source.String()] {
+		return false
+	}
+
+	if f.relevances != nil && !f.relevances[event.Relevance.String()] {
+		return false
+	}
+
+	if (!f.since.IsZero() && found.Before(f.since)) || (!f.until.IsZero() && found.After(f.until)) {
+		return false
+	}
+
+	if len(f.terms) == 0 && len(f.patterns) == 0 {
+		return true
+	}
+
+	fields := []string{event.Url, event.Permalink, event.File, event.Match}
+	for _, value := range event.AdditionalInfo {
+		fields = append(fields, value)
+	}
+
+	for _, term := range f.terms {
+		if !anyField(fields, func(field string) bool { return strings.Contains(strings.ToLower(field), term) }) {
+			return false
+		}
+	}
+
+	for _, pattern := range f.patterns {
+		if !anyField(fields, pattern.MatchString) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func anyField(fields []string, match func(string) bool) bool {
+	for _, field := range fields {
+		if match(field) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sortColumn is the Details column findings are sorted by, or empty to show
+// them in the order they were found.
+var sortColumn = ""
+var sortDescending = false
+
+// cycleSort sorts by column, then by column in reverse, then not at all.
+func cycleSort(column string) {
+	switch {
+	case sortColumn != column:
+		sortColumn, sortDescending = column, false
+	case !sortDescending:
+		sortDescending = true
+	default:
+		sortColumn, sortDescending = "", false
+	}
+}
+
+// sortFindings sorts events by sortColumn. Severities sort by rank, numbers
+// numerically and everything else alphabetically.
+func sortFindings(events []*MatchEvent) {
+	if sortColumn == "" {
+		return
+	}
+
+	redaction := session.CurrentConfig().Redaction
+	values := make(map[*MatchEvent]string, len(events))
+	for _, event := range events {
+		if sortColumn == "Status" {
+			values[event] = session.Triage.Status(event)
+		} else {
+			values[event], _ = event.GetColumn(sortColumn, redaction)
+		}
+	}
+
+	less := func(a, b string) bool {
+		if sortColumn == "Severity" {
+			return SeverityRank(a) < SeverityRank(b)
+		}
+		if x, err := strconv.Atoi(a); err == nil {
+			if y, err := strconv.Atoi(b); err == nil {
+				return x < y
+			}
+		}
+		return strings.ToLower(a) < strings.ToLower(b)
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		if sortDescending {
+			return less(values[events[j]], values[events[i]])
+		}
+		return less(values[events[i]], values[events[j]])
+	})
+}
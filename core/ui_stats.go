@@ -0,0 +1,201 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rivo/tview"
+)
+
+// cloneRateWindow is the period repos/min is averaged over.
+const cloneRateWindow = time.Minute
+
+type cloneSample struct {
+	at     time.Time
+	cloned uint64
+}
+
+// cloneSamples holds the number of clones seen at each status refresh within
+// the last cloneRateWindow, oldest first.
+var cloneSamples []cloneSample
+
+// recordCloneSample remembers the clone count at now and forgets samples
+// that have left the window. It is only called from the UI's refresh loop.
+func recordCloneSample(now time.Time) {
+	cloneSamples = append(cloneSamples, cloneSample{at: now, cloned: session.Stats.GetReposCloned()})
+
+	for len(cloneSamples) > 2 && now.Sub(cloneSamples[1].at) >= cloneRateWindow {
+		cloneSamples = cloneSamples[1:]
+	}
+}
+
+// reposPerMinute is the clone rate over the samples in the window.
+func reposPerMinute() float64 {
+	if len(cloneSamples) < 2 {
+		return 0
+	}
+
+	first, last := cloneSamples[0], cloneSamples[len(cloneSamples)-1]
+	elapsed := last.at.Sub(first.at)
+	if elapsed <= 0 {
+		return 0
+	}
+
+	return float64(last.cloned-first.cloned) / elapsed.Minutes()
+}
+
+type tokenState struct {
+	label   string
+	limit   RateLimit
+	known   bool
+	limited bool
+}
+
+// tokenStates describes every configured GitHub token from the rate limits
+// GitHub last reported for it.
+func tokenStates() []tokenState {
+	rateLimits := session.Stats.GetRateLimits()
+	now := time.Now()
+
+	var states []tokenState
+	for _, token := range session.CurrentConfig().GitHubAccessTokens {
+		label := session.TokenLabel(token)
+		limit, known := rateLimits[label]
+		states = append(states, tokenState{
+			label:   label,
+			limit:   limit,
+			known:   known,
+			limited: known && limit.Remaining == 0 && limit.Reset.After(now),
+		})
+	}
+
+	return states
+}
+
+// tokenSummary reports how many tokens are usable and, if any are rate
+// limited, when the first of them resets.
+func tokenSummary() string {
+	active, limited := 0, 0
+	var nextReset time.Time
+
+	for _, state := range tokenStates() {
+		if !state.limited {
+			active++
+			continue
+		}
+
+		limited++
+		if nextReset.IsZero() || state.limit.Reset.Before(nextReset) {
+			nextReset = state.limit.Reset
+		}
+	}
+
+	if limited == 0 {
+		return fmt.Sprintf("%d active", active)
+	}
+
+	return fmt.Sprintf("%d active, [#FF8700]%d limited until %s[-]", active, limited, nextReset.Format("15:04"))
+}
+
+func queueLength(name string) int {
+	if queue := session.Queue(name); queue != nil {
+		return queue.Len()
+	}
+
+	return 0
+}
+
+func GetUpdateString() string {
+	hideLowRelevanceText := "✕"
+	if hideLowRelevance {
+		hideLowRelevanceText = "✓"
+	}
+
+	showResolvedText := "✕"
+	if showResolved {
+		showResolvedText = "✓"
+	}
+
+	findings := int(session.Stats.GetFindings())
+
+	return fmt.Sprintf("[#00FFFF]%s[-] Queued: [::b]%d[::-] repos, [::b]%d[::-] searches | [::b]%.1f[::-] repos/min | [::b]%d[::-] %s | Tokens: %s | [::bu]H[::-]ide low relevance: %s | Show [::bu]r[::-]esolved: %s | [::bu]/[::-] Filter | [::bu]S[::-]tats | [::bu]Q[::-]uit",
+		getSpinnerCharacter(),
+		queueLength(QueueRepositories),
+		queueLength(QueueSearchResults),
+		reposPerMinute(),
+		findings,
+		Pluralize(findings, "finding", "findings"),
+		tokenSummary(),
+		hideLowRelevanceText,
+		showResolvedText)
+}
+
+// GetStatsString renders the stats panel: the pipeline's counters broken down
+// by queue, source and token.
+func GetStatsString() string {
+	var b strings.Builder
+	stats := session.Stats
+
+	section := func(title string) {
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "[#00FFFF::b]%s[-::-]\n", title)
+	}
+	row := func(name string, value interface{}) {
+		fmt.Fprintf(&b, " %-16s %v\n", name, value)
+	}
+
+	section("Queued")
+	row("repositories", queueLength(QueueRepositories))
+	row("gists", queueLength(QueueGists))
+	row("comments", queueLength(QueueComments))
+	row("search results", queueLength(QueueSearchResults))
+
+	section("Polled")
+	row("events", stats.GetEventsPolled())
+	row("gists", stats.GetGistsPolled())
+
+	section("Cloned")
+	row("succeeded", stats.GetReposCloned())
+	row("failed", stats.GetReposFailed())
+	row("per minute", fmt.Sprintf("%.1f", reposPerMinute()))
+
+	section("Files")
+	row("scanned", stats.GetFilesScanned())
+	row("scanned MB", fmt.Sprintf("%.1f", float64(stats.GetBytesScanned())/(1024*1024)))
+	row("binary skipped", stats.GetBinaryFilesSkipped())
+	row("filtered", stats.GetFilteredFilesSkipped())
+
+	section("Findings")
+	sources := stats.GetFindingsBySource()
+	names := make([]string, 0, len(sources))
+	for source := range sources {
+		names = append(names, source)
+	}
+	sort.Strings(names)
+	for _, source := range names {
+		row(source, sources[source])
+	}
+	row("total", stats.GetFindings())
+	row("baselined", stats.GetBaselinedFindings())
+
+	section("Tokens")
+	for _, state := range tokenStates() {
+		switch {
+		case !state.known:
+			fmt.Fprintf(&b, " %s\n   not used yet\n", tview.Escape(state.label))
+		case state.limited:
+			fmt.Fprintf(&b, " %s\n   [#FF8700]limited until %s[-]\n", tview.Escape(state.label), state.limit.Reset.Format("15:04:05"))
+		default:
+			fmt.Fprintf(&b, " %s\n   %d/%d left, resets %s\n", tview.Escape(state.label), state.limit.Remaining, state.limit.Limit, state.limit.Reset.Format("15:04"))
+		}
+	}
+
+	section("Signatures")
+	row("loaded", len(session.CurrentSignatures()))
+
+	return b.String()
+}
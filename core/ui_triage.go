@@ -0,0 +1,124 @@
+package core
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/rivo/tview"
+)
+
+// triageKeys maps the keys pressed on the Details table to the status they
+// give the selected finding. An empty status clears it.
+var triageKeys = map[rune]string{
+	't': TriageTruePositive,
+	'f': TriageFalsePositive,
+	'k': TriageRevoked,
+	'i': TriageIgnored,
+	'u': "",
+}
+
+const (
+	allowlistSecret  = "Allowlist secret"
+	allowlistPath    = "Allowlist path"
+	allowlistNothing = "Only this finding"
+)
+
+// selectedEvent returns the finding on the selected row of the Details table.
+func (ui *UI) selectedEvent() *MatchEvent {
+	row, column := ui.DetailsWindow.GetSelection()
+	if row < 1 {
+		return nil
+	}
+
+	if event, ok := ui.DetailsWindow.GetCell(row, column).GetReference().(*MatchEvent); ok {
+		return event
+	}
+
+	return nil
+}
+
+// triage gives event status and redraws the details, keeping the selection
+// in place as resolved findings disappear from under it.
+func (ui *UI) triage(event *MatchEvent, status string) {
+	if session.Triage == nil {
+		return
+	}
+
+	if err := session.Triage.Set(event, status); err != nil {
+		session.Log.Error("Could not save triage status: %s", err)
+		return
+	}
+
+	row, column := ui.DetailsWindow.GetSelection()
+	ui.redrawSelectedSignature()
+
+	if rows := ui.DetailsWindow.GetRowCount(); row >= rows {
+		row = rows - 1
+	}
+	if row < 1 {
+		row = 1
+	}
+	ui.DetailsWindow.Select(row, column)
+}
+
+// confirmFalsePositive asks whether to also allowlist the secret or path of
+// a finding being marked as a false positive, so it is not reported again.
+func (ui *UI) confirmFalsePositive(event *MatchEvent) {
+	var buttons []string
+	if session.HasSignatureConfig(event.Signature) {
+		if event.Part == PartContents && event.Match != "" {
+			buttons = append(buttons, allowlistSecret)
+		}
+		if event.File != "" {
+			buttons = append(buttons, allowlistPath)
+		}
+	}
+
+	if len(buttons) == 0 {
+		ui.triage(event, TriageFalsePositive)
+		return
+	}
+
+	text := fmt.Sprintf("Mark this %s finding as a false positive?\n\nAllowlisting the secret or the path adds a rule to %s so that it is not reported again.",
+		event.Signature, filepath.Join(SignaturePacksDir, TriageAllowlistFile))
+
+	modal := tview.NewModal().
+		SetText(text).
+		AddButtons(append(buttons, allowlistNothing, "Cancel")).
+		SetDoneFunc(func(_ int, label string) {
+			ui.Pages.RemovePage("triage")
+			ui.App.SetFocus(ui.DetailsWindow)
+
+			var allowlist func(*MatchEvent) (string, error)
+			switch label {
+			case allowlistSecret:
+				allowlist = session.AllowlistSecret
+			case allowlistPath:
+				allowlist = session.AllowlistPath
+			case allowlistNothing:
+			default:
+				return
+			}
+
+			ui.triage(event, TriageFalsePositive)
+			if allowlist == nil {
+				return
+			}
+
+			path, err := allowlist(event)
+			if err != nil {
+				session.Log.Error("Could not add an allowlist rule to %s: %s", path, err)
+				return
+			}
+
+			session.Log.Info("Added an allowlist rule for %s to %s", event.Signature, path)
+			go func() {
+				if err := session.Reload(false); err != nil {
+					session.Log.Error("%s", err)
+				}
+			}()
+		})
+
+	ui.Pages.AddPage("triage", modal, true, true)
+	ui.App.SetFocus(modal)
+}
@@ -1,16 +1,28 @@
 package core
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
 	"crypto/sha1"
+	"crypto/subtle"
 	"encoding/hex"
+	"encoding/json"
 	"math"
+	"net"
+	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 )
 
+// GetTempDir returns a directory for suffix in the temp directory and
+// remembers it, so that Shutdown removes it and nothing else in there.
 func GetTempDir(suffix string) string {
 	dir := filepath.Join(*session.Options.TempDirectory, suffix)
+	session.trackTempDir(dir)
 
 	if _, err := os.Stat(dir); os.IsNotExist(err) {
 		os.MkdirAll(dir, os.ModePerm)
@@ -69,3 +81,104 @@ func GetEntropy(data string) (entropy float64) {
 
 	return entropy
 }
+
+// LineIndex maps byte offsets in some contents to line and column numbers.
+type LineIndex []int
+
+// NewLineIndex records the offset at which every line in contents starts.
+func NewLineIndex(contents []byte) LineIndex {
+	index := LineIndex{0}
+	for i, b := range contents {
+		if b == '\n' {
+			index = append(index, i+1)
+		}
+	}
+
+	return index
+}
+
+// Position returns the 1-based line and column of offset.
+func (index LineIndex) Position(offset int) (line int, column int) {
+	line = sort.Search(len(index), func(i int) bool { return index[i] > offset })
+
+	return line, offset - index[line-1] + 1
+}
+
+// Text returns the contents of the 1-based line, without its line ending.
+func (index LineIndex) Text(contents []byte, line int) []byte {
+	if line < 1 || line > len(index) {
+		return nil
+	}
+
+	end := len(contents)
+	if line < len(index) {
+		end = index[line] - 1
+	}
+
+	return bytes.TrimRight(contents[index[line-1]:end], "\r")
+}
+
+// serveUntilDone serves handler on listener until ctx is done, then gives
+// requests in progress a few seconds to finish.
+func serveUntilDone(ctx context.Context, listener net.Listener, handler http.Handler) error {
+	server := &http.Server{Handler: handler}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	if err := server.Serve(listener); err != http.ErrServerClosed {
+		return err
+	}
+
+	return nil
+}
+
+// randomId returns 16 random bytes, hex encoded.
+func randomId() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(id), nil
+}
+
+// IsLoopback reports whether addr, the address of a listener, only accepts
+// connections from this machine.
+func IsLoopback(addr net.Addr) bool {
+	if tcp, ok := addr.(*net.TCPAddr); ok {
+		return tcp.IP.IsLoopback()
+	}
+
+	return false
+}
+
+// bearerAuthorized reports whether r carries token as a bearer token. An
+// empty token allows every request.
+func bearerAuthorized(r *http.Request, token string) bool {
+	if token == "" {
+		return true
+	}
+
+	given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+// queryAuthorized is bearerAuthorized for browsers, which cannot set headers
+// on an EventSource: the token may also be given as the token parameter.
+func queryAuthorized(r *http.Request, token string) bool {
+	if bearerAuthorized(r, token) {
+		return true
+	}
+
+	return subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("token")), []byte(token)) == 1
+}
+
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(value)
+}
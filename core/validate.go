@@ -0,0 +1,256 @@
+package core
+
+import (
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var validParts = []string{PartExtension, PartFilename, PartPath, PartContents}
+
+// ConfigError is a problem found in a configuration file, located by line and
+// column so it can be fixed without hunting for it.
+type ConfigError struct {
+	File    string
+	Line    int
+	Column  int
+	Message string
+}
+
+func (e ConfigError) Error() string {
+	return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Column, e.Message)
+}
+
+// yamlFields returns the YAML keys a struct accepts, from its yaml tags.
+func yamlFields(v interface{}) map[string]bool {
+	fields := make(map[string]bool)
+	t := reflect.TypeOf(v)
+
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if name != "" && name != "-" {
+			fields[name] = true
+		}
+	}
+
+	return fields
+}
+
+func isOneOf(value string, allowed []string) bool {
+	for _, a := range allowed {
+		if value == a {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ValidateConfigFile checks the configuration file at path and returns every
+// problem found rather than stopping at the first one.
+func ValidateConfigFile(path string) ([]ConfigError, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ValidateConfig(path, data), nil
+}
+
+// ValidateConfig checks configuration data read from file: YAML syntax and
+// types, unknown keys, and for every signature a missing name, duplicate
+// name, unknown part, empty or invalid pattern, unknown severity or confidence,
+// and fields that can never take effect, and for every allowlist rule unknown
+// fields, a missing signature and invalid patterns.
+func ValidateConfig(file string, data []byte) []ConfigError {
+	var errors []ConfigError
+	report := func(node *yaml.Node, format string, args ...interface{}) {
+		errors = append(errors, ConfigError{File: file, Line: node.Line, Column: node.Column, Message: fmt.Sprintf(format, args...)})
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return []ConfigError{{File: file, Message: err.Error()}}
+	}
+
+	if err := yaml.Unmarshal(data, &Config{}); err != nil {
+		if typeError, ok := err.(*yaml.TypeError); ok {
+			for _, message := range typeError.Errors {
+				errors = append(errors, ConfigError{File: file, Message: message})
+			}
+		}
+	}
+
+	if len(root.Content) == 0 {
+		return errors
+	}
+
+	document := root.Content[0]
+	if document.Kind != yaml.MappingNode {
+		report(document, "expected a mapping at the top level")
+		return errors
+	}
+
+	configFields := yamlFields(Config{})
+	var signatures, allowlists *yaml.Node
+	for i := 0; i+1 < len(document.Content); i += 2 {
+		key, value := document.Content[i], document.Content[i+1]
+		if !configFields[key.Value] {
+			report(key, "unknown field %q", key.Value)
+		}
+
+		switch key.Value {
+		case "signatures":
+			signatures = value
+		case "allowlists":
+			allowlists = value
+		}
+	}
+
+	if allowlists != nil && allowlists.Kind == yaml.SequenceNode {
+		ruleFields := yamlFields(ConfigAllowlistRule{})
+		for i, node := range allowlists.Content {
+			var rule ConfigAllowlistRule
+			if node.Kind != yaml.MappingNode || node.Decode(&rule) != nil {
+				report(node, "allowlists[%d]: expected a mapping", i)
+				continue
+			}
+
+			for j := 0; j+1 < len(node.Content); j += 2 {
+				if key := node.Content[j]; !ruleFields[key.Value] {
+					report(key, "allowlists[%d]: unknown field %q", i, key.Value)
+				}
+			}
+
+			if rule.Signature == "" {
+				report(node, "allowlists[%d]: missing signature", i)
+			}
+
+			allowlist := ConfigAllowlist{Regexes: rule.Regexes, Paths: rule.Paths, Stopwords: rule.Stopwords, Secrets: rule.Secrets}
+			if _, err := compileAllowlist(allowlist, ""); err != nil {
+				report(node, "allowlists[%d]: invalid %s", i, err)
+			}
+		}
+	}
+
+	if signatures == nil || signatures.Kind != yaml.SequenceNode {
+		return errors
+	}
+
+	signatureFields := yamlFields(ConfigSignature{})
+	names := make(map[string]*yaml.Node)
+
+	for i, node := range signatures.Content {
+		if node.Kind != yaml.MappingNode {
+			report(node, "signatures[%d]: expected a mapping", i)
+			continue
+		}
+
+		var signature ConfigSignature
+		if err := node.Decode(&signature); err != nil {
+			report(node, "signatures[%d]: %s", i, err)
+			continue
+		}
+
+		label := fmt.Sprintf("signatures[%d]", i)
+		if signature.Name != "" {
+			label = fmt.Sprintf("signature %q", signature.Name)
+		}
+
+		keys := make(map[string]*yaml.Node)
+		for j := 0; j+1 < len(node.Content); j += 2 {
+			key := node.Content[j]
+			keys[key.Value] = node.Content[j+1]
+
+			if !signatureFields[key.Value] {
+				report(key, "%s: unknown field %q", label, key.Value)
+			}
+		}
+
+		if signature.Name == "" {
+			report(node, "%s: missing name", label)
+		} else {
+			if first, exists := names[signature.Name]; exists {
+				report(keys["name"], "%s: duplicate name, first defined on line %d", label, first.Line)
+			} else {
+				names[signature.Name] = keys["name"]
+			}
+		}
+
+		if partNode, exists := keys["part"]; !exists {
+			report(node, "%s: missing part, expected one of %s", label, strings.Join(validParts, ", "))
+		} else if !isOneOf(signature.Part, validParts) {
+			report(partNode, "%s: unknown part %q, expected one of %s", label, signature.Part, strings.Join(validParts, ", "))
+		}
+
+		switch {
+		case signature.Match == "" && signature.Regex == "":
+			report(node, "%s: empty pattern, set either match or regex", label)
+		case signature.Match != "" && signature.Regex != "":
+			report(keys["regex"], "%s: regex is never used because match is also set", label)
+		case signature.Match != "" && signature.Part == PartContents:
+			report(keys["match"], "%s: match never applies to contents, use regex instead", label)
+		case signature.Regex != "":
+			if compiled, err := regexp.Compile(signature.Regex); err != nil {
+				report(keys["regex"], "%s: invalid regex: %s", label, err)
+			} else if signature.SecretGroup < 0 || signature.SecretGroup > compiled.NumSubexp() {
+				report(keys["secret_group"], "%s: secret_group %d does not exist, the regex has %d groups", label, signature.SecretGroup, compiled.NumSubexp())
+			}
+		}
+
+		if (len(signature.Keywords) > 0 || signature.Entropy > 0 || signature.SecretGroup > 0) && (signature.Part != PartContents || signature.Regex == "") {
+			report(node, "%s: keywords, entropy and secret_group only apply to contents regex signatures", label)
+		}
+
+		if allowlist, exists := keys["allowlist"]; exists {
+			allowlistFields := yamlFields(ConfigAllowlist{})
+			for j := 0; j+1 < len(allowlist.Content); j += 2 {
+				if key := allowlist.Content[j]; !allowlistFields[key.Value] {
+					report(key, "%s: unknown allowlist field %q", label, key.Value)
+				}
+			}
+
+			if _, err := compileAllowlist(signature.Allowlist, ""); err != nil {
+				report(allowlist, "%s: invalid %s", label, err)
+			}
+		}
+
+		if signature.Search != "" && (signature.Part != PartContents || signature.Regex == "") {
+			report(keys["search"], "%s: search results are only matched by contents regex signatures", label)
+		}
+
+		if severity := strings.ToLower(signature.Severity); severity != "" {
+			if _, exists := severityRanks[severity]; !exists {
+				report(keys["severity"], "%s: unknown severity %q, expected one of %s", label, signature.Severity, strings.Join(sortedSeverities(), ", "))
+			}
+		}
+
+		if confidence := strings.ToLower(signature.Confidence); confidence != "" && !isOneOf(confidence, []string{ConfidenceLow, ConfidenceMedium, ConfidenceHigh}) {
+			report(keys["confidence"], "%s: unknown confidence %q, expected one of low, medium, high", label, signature.Confidence)
+		}
+	}
+
+	sort.SliceStable(errors, func(i, j int) bool {
+		return errors[i].Line < errors[j].Line
+	})
+
+	return errors
+}
+
+func sortedSeverities() []string {
+	severities := make([]string, 0, len(severityRanks))
+	for severity := range severityRanks {
+		severities = append(severities, severity)
+	}
+
+	sort.Slice(severities, func(i, j int) bool {
+		return severityRanks[severities[i]] < severityRanks[severities[j]]
+	})
+
+	return severities
+}
@@ -34,15 +34,26 @@ type ShodanAPIInfo struct {
 // }
 
 func (s *Session) InitValidators() {
-	s.Validators = make(map[string]Validator)
+	validators := s.newValidators()
 
-	s.Validators["default"] = func(signature string, match string) (bool, ValidationInfo, Relevance) {
+	s.Lock()
+	s.Validators = validators
+	s.Unlock()
+}
+
+// newValidators returns the validators for each signature, by name.
+func (s *Session) newValidators() map[string]Validator {
+	validators := make(map[string]Validator)
+
+	validators["default"] = func(signature string, match string) (bool, ValidationInfo, Relevance) {
 		return true, ValidationInfo{}, RelevanceMedium
 	}
 
-	shodanKeyCache = map[string]bool{}
-	shodanNextAPICall = time.Now()
-	s.Validators["Shodan API Key"] = func(signature string, match string) (bool, ValidationInfo, Relevance) {
+	if shodanKeyCache == nil {
+		shodanKeyCache = map[string]bool{}
+		shodanNextAPICall = time.Now()
+	}
+	validators["Shodan API Key"] = func(signature string, match string) (bool, ValidationInfo, Relevance) {
 		info := ValidationInfo{}
 		relevance := RelevanceLow
 
@@ -77,7 +88,7 @@ func (s *Session) InitValidators() {
 				apiInfo := ShodanAPIInfo{}
 				json.Unmarshal(rawData, &apiInfo)
 
-				info["Key"] = match
+				info["Key"] = s.Redact(match)
 				info["Plan"] = apiInfo.Plan
 				info["Query credits"] = fmt.Sprint(apiInfo.QueryCredits)
 				info["Scan credits"] = fmt.Sprint(apiInfo.ScanCredits)
@@ -91,13 +102,22 @@ func (s *Session) InitValidators() {
 			return isValidKey, info, relevance
 		}
 	}
+
+	return validators
 }
 
 func (s *Session) GetValidator(signature string) Validator {
+	s.Lock()
+	defer s.Unlock()
+
 	validator, contains := s.Validators[signature]
-	if contains {
-		return validator
-	} else {
-		return s.Validators["default"]
+	if !contains {
+		validator = s.Validators["default"]
+	}
+
+	return func(signature string, match string) (bool, ValidationInfo, Relevance) {
+		valid, info, relevance := validator(signature, match)
+		s.Stats.RecordValidation(signature, valid)
+		return valid, info, relevance
 	}
 }
@@ -1,17 +1,31 @@
 package core
 
 func (s *Session) InitViews() {
-	s.Views = make(map[string][]string)
+	views := newViews()
 
-	s.Views["Default"] = []string{"Repository", "Match"}
-	s.Views["Shodan API Key"] = []string{"Key", "Plan", "Query credits", "Scan credits", "URL"}
+	s.Lock()
+	s.Views = views
+	s.Unlock()
+}
+
+// newViews returns the columns shown for each signature, by name.
+func newViews() map[string][]string {
+	views := make(map[string][]string)
+
+	views["Default"] = []string{"Severity", "Repository", "File", "Line", "Match"}
+	views["Shodan API Key"] = []string{"Key", "Plan", "Query credits", "Scan credits", "URL"}
+
+	return views
 }
 
 func (s *Session) GetView(signature string) []string {
+	s.Lock()
+	defer s.Unlock()
+
 	view, contains := s.Views[signature]
 	if contains {
 		return view
 	} else {
-		return s.Views["default"]
+		return s.Views["Default"]
 	}
 }
@@ -0,0 +1,118 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrLeaseExpired is returned when the coordinator no longer knows a lease,
+// because it timed out and the item was handed to another worker.
+var ErrLeaseExpired = errors.New("lease expired")
+
+// CoordinatorClient leases work from a coordinator on behalf of a worker.
+type CoordinatorClient struct {
+	url    string
+	token  string
+	worker string
+	client *http.Client
+}
+
+// NewCoordinatorClient returns a client for the coordinator at url. The
+// worker is identified to the coordinator by its host name and process ID.
+func NewCoordinatorClient(coordinator string, token string) *CoordinatorClient {
+	host, _ := os.Hostname()
+
+	return &CoordinatorClient{
+		url:    strings.TrimSuffix(coordinator, "/"),
+		token:  token,
+		worker: fmt.Sprintf("%s-%d", host, os.Getpid()),
+		client: &http.Client{Timeout: leaseWait + 10*time.Second},
+	}
+}
+
+// Worker returns the ID the client reports to the coordinator.
+func (c *CoordinatorClient) Worker() string {
+	return c.worker
+}
+
+// Lease waits for the next item of work, returning nil if the coordinator
+// has none.
+func (c *CoordinatorClient) Lease(ctx context.Context) (*Lease, error) {
+	var lease Lease
+	found, err := c.post(ctx, "/v1/leases?worker="+url.QueryEscape(c.worker), nil, &lease)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	return &lease, nil
+}
+
+// Heartbeat extends a lease while its work is still running.
+func (c *CoordinatorClient) Heartbeat(ctx context.Context, id string) error {
+	_, err := c.post(ctx, "/v1/leases/"+id+"/heartbeat", nil, nil)
+	return err
+}
+
+// Complete reports the findings for a lease.
+func (c *CoordinatorClient) Complete(ctx context.Context, id string, findings []*MatchEvent) error {
+	_, err := c.post(ctx, "/v1/leases/"+id+"/complete", &LeaseReport{Findings: findings}, nil)
+	return err
+}
+
+// Fail gives a lease back to be retried.
+func (c *CoordinatorClient) Fail(ctx context.Context, id string, reason error) error {
+	_, err := c.post(ctx, "/v1/leases/"+id+"/fail", &LeaseReport{Error: reason.Error()}, nil)
+	return err
+}
+
+// post sends body to path and decodes the response in to result. It returns
+// false if the coordinator answered with no content.
+func (c *CoordinatorClient) post(ctx context.Context, path string, body interface{}, result interface{}) (bool, error) {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return false, err
+		}
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url+path, bytes.NewReader(payload))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if c.token != "" {
+		request.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	response, err := c.client.Do(request)
+	if err != nil {
+		return false, err
+	}
+	defer response.Body.Close()
+
+	switch {
+	case response.StatusCode == http.StatusNoContent:
+		return false, nil
+	case response.StatusCode == http.StatusNotFound && strings.HasPrefix(path, "/v1/leases/"):
+		return false, ErrLeaseExpired
+	case response.StatusCode != http.StatusOK:
+		return false, fmt.Errorf("coordinator returned status code %d", response.StatusCode)
+	}
+
+	if result != nil {
+		if err := json.NewDecoder(response.Body).Decode(result); err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
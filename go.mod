@@ -3,9 +3,10 @@ module github.com/eth0izzle/shhgit
 go 1.14
 
 require (
+	github.com/BurntSushi/toml v1.2.1
 	github.com/Microsoft/go-winio v0.5.1 // indirect
 	github.com/fatih/color v1.13.0
-	github.com/gdamore/tcell/v2 v2.4.1-0.20210905002822-f057f0a857a1 // indirect
+	github.com/gdamore/tcell/v2 v2.4.1-0.20210905002822-f057f0a857a1
 	github.com/golang/protobuf v1.5.2 // indirect
 	github.com/google/go-github v17.0.0+incompatible
 	github.com/google/go-querystring v1.1.0 // indirect
@@ -1,48 +1,39 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
-	"context"
+	"flag"
+	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
 	"strings"
-	"time"
 
 	"github.com/eth0izzle/shhgit/core"
 	"github.com/fatih/color"
 )
 
-var session = core.GetSession()
+var session *core.Session
 
 func ProcessRepositories() {
 	threadNum := *session.Options.Threads
 
 	for i := 0; i < threadNum; i++ {
+		session.Workers.Add(1)
 		go func(tid int) {
+			defer session.Workers.Done()
 			for {
-				timeout := time.Duration(*session.Options.CloneRepositoryTimeout) * time.Second
-				_, cancel := context.WithTimeout(context.Background(), timeout)
-				defer cancel()
-
-				repository := <-session.Repositories
-
-				repo, err := core.GetRepository(session, repository.Id)
-
+				var repository core.GitResource
+				ticket, err := session.Repositories.Pop(session.Context, &repository)
 				if err != nil {
-					session.Log.Warn("Failed to retrieve repository %d: %s", repository.Id, err)
-					continue
+					return
 				}
 
-				if repo.GetPermissions()["pull"] &&
-					uint(repo.GetStargazersCount()) >= *session.Options.MinimumStars &&
-					uint(repo.GetSize()) < *session.Options.MaximumRepositorySize {
-
-					processRepositoryOrGist(repo.GetCloneURL(), repository.Ref, repo.GetStargazersCount(), core.GITHUB_SOURCE)
-				}
+				processRepository(repository, publish)
+				session.Ack(session.Repositories, ticket)
 			}
 		}(i)
 	}
@@ -52,10 +43,18 @@ func ProcessGists() {
 	threadNum := *session.Options.Threads
 
 	for i := 0; i < threadNum; i++ {
+		session.Workers.Add(1)
 		go func(tid int) {
+			defer session.Workers.Done()
 			for {
-				gistUrl := <-session.Gists
-				processRepositoryOrGist(gistUrl, "", -1, core.GIST_SOURCE)
+				var gistUrl string
+				ticket, err := session.Gists.Pop(session.Context, &gistUrl)
+				if err != nil {
+					return
+				}
+
+				processRepositoryOrGist(gistUrl, "", -1, core.GIST_SOURCE, publish)
+				session.Ack(session.Gists, ticket)
 			}
 		}(i)
 	}
@@ -65,15 +64,18 @@ func ProcessComments() {
 	threadNum := *session.Options.Threads
 
 	for i := 0; i < threadNum; i++ {
+		session.Workers.Add(1)
 		go func(tid int) {
+			defer session.Workers.Done()
 			for {
-				commentBody := <-session.Comments
-				dir := core.GetTempDir(core.GetHash(commentBody))
-				ioutil.WriteFile(filepath.Join(dir, "comment.ignore"), []byte(commentBody), 0644)
-
-				if !checkSignatures(dir, "ISSUE", 0, core.GITHUB_COMMENT) {
-					os.RemoveAll(dir)
+				var commentBody string
+				ticket, err := session.Comments.Pop(session.Context, &commentBody)
+				if err != nil {
+					return
 				}
+
+				processComment(commentBody, publish)
+				session.Ack(session.Comments, ticket)
 			}
 		}(i)
 	}
@@ -83,41 +85,146 @@ func ProcessSearches() {
 	threadNum := *session.Options.Threads
 
 	for i := 0; i < threadNum; i++ {
+		session.Workers.Add(1)
 		go func() {
+			defer session.Workers.Done()
 			for {
-				searchResult := <-session.SearchResults
-				resp, err := http.Get(searchResult.RawUrl)
+				searchResult, ticket, err := session.PopSearchResult(session.Context)
 				if err != nil {
-					session.Log.Error("Error while retrieving results from %s: %s.", searchResult.RawUrl, err)
-					continue
-				}
-				defer resp.Body.Close()
-
-				if resp.StatusCode == 200 {
-					html, err := ioutil.ReadAll(resp.Body)
-					if err != nil {
-						session.Log.Error("Error while processing HTML request from %s: %s.", searchResult.RawUrl, err)
-						continue
-					}
-
-					validator := session.GetValidator(searchResult.Signature.Name())
-					matches := searchResult.Signature.GetContentsMatches(html)
-					for _, match := range matches {
-						valid, additionalInfo, relevance := validator(searchResult.Signature.Name(), match)
-						if valid {
-							session.Log.Important("%s: Matched %s for %s.", searchResult.Url, match, searchResult.Signature.Name())
-							publish(&core.MatchEvent{Source: 1, Url: searchResult.Url, Match: match, Signature: searchResult.Signature.Name(), AdditionalInfo: additionalInfo, Relevance: relevance})
-						}
-					}
-				} else {
-					session.Log.Warn("Failed to retrieve %s, status code %d", searchResult.Url, resp.StatusCode)
+					return
 				}
+
+				processSearchResult(searchResult, publish)
+				session.Ack(session.SearchResults, ticket)
 			}
 		}()
 	}
 }
 
-func processRepositoryOrGist(url string, ref string, stars int, source core.GitResourceType) {
+// processComment scans the body of an issue or issue comment.
+func processComment(commentBody string, report func(*core.MatchEvent)) error {
+	dir := core.GetTempDir(core.GetHash(commentBody))
+	if err := ioutil.WriteFile(filepath.Join(dir, "comment.ignore"), []byte(commentBody), 0644); err != nil {
+		return err
+	}
+
+	if !checkSignatures(dir, "ISSUE", "", 0, core.GITHUB_COMMENT, report) {
+		os.RemoveAll(dir)
+	}
+
+	return nil
+}
+
+// processSearchResult fetches a file found by code search and reports the
+// matches of the signature that found it which pass its validator.
+func processSearchResult(searchResult core.SearchResult, report func(*core.MatchEvent)) error {
+	request, err := http.NewRequestWithContext(session.Context, http.MethodGet, searchResult.RawUrl, nil)
+	if err != nil {
+		session.Log.Error("Invalid URL %s: %s.", searchResult.RawUrl, err)
+		return nil
+	}
+
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		session.Log.Error("Error while retrieving results from %s: %s.", searchResult.RawUrl, err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		session.Log.Warn("Failed to retrieve %s, status code %d", searchResult.Url, resp.StatusCode)
+		if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+			return fmt.Errorf("status code %d", resp.StatusCode)
+		}
+		return nil
+	}
+
+	html, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		session.Log.Error("Error while processing HTML request from %s: %s.", searchResult.RawUrl, err)
+		return err
+	}
+
+	validator := session.GetValidator(searchResult.Signature.Name())
+	localPath := ""
+	lines := core.NewLineIndex(html)
+	blobs := append([]core.DecodedBlob{{Contents: html}}, core.DecodeBlobs(html, int(*session.Options.DecodeDepth))...)
+	for _, blob := range blobs {
+		matches := searchResult.Signature.GetContentsMatches(blob.Contents)
+		for _, match := range matches {
+			line, column := locate(lines, blob, match)
+			if core.HasAllowMarker(lines.Text(html, line)) {
+				continue
+			}
+
+			valid, additionalInfo, relevance := validator(searchResult.Signature.Name(), match.Value)
+			if valid {
+				if localPath == "" {
+					localPath = keepSearchResult(searchResult.Url, html)
+				}
+
+				session.Log.Important("%s#L%d: Matched %s for %s.", searchResult.Url, line, session.Redact(match.Value), searchResult.Signature.Name())
+				report(&core.MatchEvent{
+					SignatureMetadata: searchResult.Signature.Metadata(),
+					Source:            1,
+					Url:               searchResult.Url,
+					Permalink:         core.GetPermalink(searchResult.Url, "", "", line),
+					Match:             match.Value,
+					Signature:         searchResult.Signature.Name(),
+					Line:              line,
+					Column:            column,
+					AdditionalInfo:    additionalInfo,
+					Relevance:         relevance,
+					Decoding:          blob.ChainString(),
+					Part:              core.PartContents,
+					LocalPath:         localPath,
+				})
+			}
+		}
+	}
+
+	return nil
+}
+
+// keepSearchResult writes a fetched file with findings to the temp directory,
+// like a clone, so the UI can show the context of its findings.
+func keepSearchResult(url string, contents []byte) string {
+	dir := core.GetTempDir(core.GetHash(url))
+	file := filepath.Join(dir, path.Base(url))
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return ""
+	}
+
+	if err := ioutil.WriteFile(file, contents, 0644); err != nil {
+		session.Log.Debug("Could not keep %s: %s", url, err)
+		return ""
+	}
+
+	return file
+}
+
+// processRepository looks up a repository from a push event and scans it if
+// it is public and within the star and size limits.
+func processRepository(repository core.GitResource, report func(*core.MatchEvent)) error {
+	repo, err := core.GetRepository(session, repository.Id)
+
+	if err != nil {
+		session.Log.Warn("Failed to retrieve repository %d: %s", repository.Id, err)
+		return err
+	}
+
+	if repo.GetPermissions()["pull"] &&
+		uint(repo.GetStargazersCount()) >= *session.Options.MinimumStars &&
+		uint(repo.GetSize()) < *session.Options.MaximumRepositorySize {
+
+		return processRepositoryOrGist(repo.GetCloneURL(), repository.Ref, repo.GetStargazersCount(), core.GITHUB_SOURCE, report)
+	}
+
+	return nil
+}
+
+func processRepositoryOrGist(url string, ref string, stars int, source core.GitResourceType, report func(*core.MatchEvent)) error {
 	var (
 		matchedAny bool = false
 	)
@@ -128,118 +235,293 @@ func processRepositoryOrGist(url string, ref string, stars int, source core.GitR
 	if err != nil {
 		session.Log.Debug("[%s] Cloning failed: %s", url, err.Error())
 		os.RemoveAll(dir)
-		return
+		return err
 	}
 
 	session.Log.Debug("[%s] Cloning %s in to %s", url, ref, strings.Replace(dir, *session.Options.TempDirectory, "", -1))
-	matchedAny = checkSignatures(dir, url, stars, source)
-	if !matchedAny {
+	matchedAny = checkSignatures(dir, url, ref, stars, source, report)
+
+	// clones with findings are kept for the details pane, which a worker
+	// does not have; its findings are sent to the coordinator instead
+	if !matchedAny || len(*session.Options.Worker) > 0 {
 		os.RemoveAll(dir)
 	}
-}
 
-func checkSignatures(dir string, url string, stars int, source core.GitResourceType) (matchedAny bool) {
-	for _, file := range core.GetMatchingFiles(dir) {
-		var (
-			matches          []string
-			relativeFileName string
-		)
-		if strings.Contains(dir, *session.Options.TempDirectory) {
-			relativeFileName = strings.Replace(file.Path, *session.Options.TempDirectory, "", -1)
-		} else {
-			relativeFileName = strings.Replace(file.Path, dir, "", -1)
-		}
+	return nil
+}
 
-		if *session.Options.SearchQuery != "" {
-			queryRegex := regexp.MustCompile(*session.Options.SearchQuery)
-			for _, match := range queryRegex.FindAllSubmatch(file.Contents, -1) {
-				matches = append(matches, string(match[0]))
+// checkSignatures scans dir and passes every finding to report.
+func checkSignatures(dir string, url string, ref string, stars int, source core.GitResourceType, report func(*core.MatchEvent)) (matchedAny bool) {
+	scanner := session.Scanner()
+
+	if *session.Options.SearchQuery != "" {
+		queryRegex := regexp.MustCompile(*session.Options.SearchQuery)
+		for file := range scanner.Files(session.Context, dir) {
+			var found []string
+			relativeFileName := strings.TrimPrefix(strings.TrimPrefix(file.Path, filepath.ToSlash(dir)), "/")
+			lines := core.NewLineIndex(file.Contents)
+			for _, loc := range queryRegex.FindAllIndex(file.Contents, -1) {
+				line, _ := lines.Position(loc[0])
+				found = append(found, fmt.Sprintf("%s (L%d)", session.Redact(string(file.Contents[loc[0]:loc[1]])), line))
 			}
 
-			if matches != nil {
-				count := len(matches)
-				m := strings.Join(matches, ", ")
+			if found != nil {
+				count := len(found)
+				m := strings.Join(found, ", ")
 				session.Log.Important("[%s] %d %s for %s in file %s: %s", url, count, core.Pluralize(count, "match", "matches"), color.GreenString("Search Query"), relativeFileName, color.YellowString(m))
+			} else if len(*session.Options.Local) <= 0 {
+				os.Remove(file.Path)
 			}
-		} else {
-			for _, signature := range session.Signatures {
-				if len(signature.Search()) > 0 {
-					continue
-				}
+		}
 
-				if matched, part := signature.Match(file); matched {
-					if part == core.PartContents {
-						if matches = signature.GetContentsMatches(file.Contents); len(matches) > 0 {
-							count := len(matches)
-							m := strings.Join(matches, ", ")
-							for _, match := range matches {
-								publish(&core.MatchEvent{Source: source, Url: url, Match: match, Signature: signature.Name(), File: relativeFileName, Stars: stars})
-							}
-							matchedAny = true
-
-							session.Log.Important("[%s] %d %s for %s in file %s: %s", url, count, core.Pluralize(count, "match", "matches"), color.GreenString(signature.Name()), relativeFileName, color.YellowString(m))
-						}
-					} else {
-						if *session.Options.PathChecks {
-							for _, match := range matches {
-								publish(&core.MatchEvent{Source: source, Url: url, Match: match, Signature: signature.Name(), File: relativeFileName, Stars: stars})
-							}
-							matchedAny = true
-
-							session.Log.Important("[%s] Matching file %s for %s", url, color.YellowString(relativeFileName), color.GreenString(signature.Name()))
-						}
-
-						if *session.Options.EntropyThreshold > 0 && file.CanCheckEntropy() {
-							scanner := bufio.NewScanner(bytes.NewReader(file.Contents))
-
-							for scanner.Scan() {
-								line := scanner.Text()
-
-								if len(line) > 6 && len(line) < 100 {
-									entropy := core.GetEntropy(line)
-
-									if entropy >= *session.Options.EntropyThreshold {
-										blacklistedMatch := false
-
-										for _, blacklistedString := range session.Config.BlacklistedStrings {
-											if strings.Contains(strings.ToLower(line), strings.ToLower(blacklistedString)) {
-												blacklistedMatch = true
-											}
-										}
-
-										if !blacklistedMatch {
-											publish(&core.MatchEvent{Source: source, Url: url, Match: line, Signature: "High entropy string", File: relativeFileName, Stars: stars})
-											matchedAny = true
-
-											session.Log.Important("[%s] Potential secret in %s = %s", url, color.YellowString(relativeFileName), color.GreenString(line))
-										}
-									}
-								}
-							}
-						}
-					}
+		return
+	}
+
+	target := core.ScanTarget{Url: url, Ref: ref, Stars: stars, Source: source}
+	scanner.Walk(session.Context, dir, target, func(file core.MatchFile, relativeFileName string, events []*core.MatchEvent) {
+		for len(events) > 0 {
+			group := nextGroup(events)
+			events = events[len(group):]
+			first := group[0]
+			matchedAny = true
+
+			switch {
+			case first.Part != core.PartContents:
+				// path matches are only logged, there is no secret to track
+				session.Log.Important("[%s] Matching file %s for %s", url, color.YellowString(relativeFileName), color.GreenString(first.Signature))
+			case first.Signature == core.EntropySignature:
+				for _, event := range group {
+					report(event)
+					session.Log.Important("[%s] Potential secret in %s:%d = %s", url, color.YellowString(relativeFileName), event.Line, color.GreenString(session.Redact(event.Match)))
+				}
+			case first.Decoding != "":
+				for _, event := range group {
+					report(event)
+				}
+				session.Log.Important("[%s] %d %s for %s in file %s (decoded from %s): %s", url, len(group), core.Pluralize(len(group), "match", "matches"), color.GreenString(first.Signature), relativeFileName, first.Decoding, color.YellowString(joinMatches(group)))
+			default:
+				for _, event := range group {
+					report(event)
 				}
+				session.Log.Important("[%s] %d %s for %s in file %s: %s", url, len(group), core.Pluralize(len(group), "match", "matches"), color.GreenString(first.Signature), relativeFileName, color.YellowString(joinMatches(group)))
 			}
 		}
 
 		if !matchedAny && len(*session.Options.Local) <= 0 {
 			os.Remove(file.Path)
 		}
-	}
+	})
+
 	return
 }
 
+// nextGroup returns the leading events that come from the same signature,
+// part and decoding, so they can be logged on one line.
+func nextGroup(events []*core.MatchEvent) []*core.MatchEvent {
+	first := events[0]
+	for i, event := range events {
+		if event.Signature != first.Signature || event.Part != first.Part || event.Decoding != first.Decoding {
+			return events[:i]
+		}
+	}
+
+	return events
+}
+
+// locate returns where match was found in the original contents. Matches in
+// decoded blobs are reported at the start of the encoded run they came from.
+func locate(lines core.LineIndex, blob core.DecodedBlob, match core.ContentMatch) (int, int) {
+	if len(blob.Chain) == 0 {
+		return match.Line, match.Column
+	}
+
+	return lines.Position(blob.Offset)
+}
+
+func joinMatches(events []*core.MatchEvent) string {
+	values := make([]string, 0, len(events))
+	for _, event := range events {
+		values = append(values, fmt.Sprintf("%s (L%d)", session.Redact(event.Match), event.Line))
+	}
+
+	return strings.Join(values, ", ")
+}
+
 func publish(event *core.MatchEvent) {
-	core.GetUI().Publish(event)
+	if *session.Options.UpdateBaseline {
+		session.Baseline.Add(event)
+		return
+	}
+
+	if session.Baseline.Contains(event) {
+		session.Stats.IncBaselinedFindings()
+		return
+	}
+
+	if !session.IsReportable(event) {
+		return
+	}
+
+	if !session.Findings.Add(session.Fingerprint(event)) {
+		return
+	}
+
+	session.Stats.RecordFinding(event)
+	if len(*session.Options.Local) <= 0 {
+		core.GetUI().Publish(event)
+		session.Live.Publish(event)
+	}
 	core.GetSession().WriteToCsv(event)
 }
 
+// scanLocal scans the --local directory without the UI and returns the exit
+// code: 1 if any finding is not suppressed by the baseline, 0 otherwise.
+func scanLocal() int {
+	dir := *session.Options.Local
+	checkSignatures(dir, dir, "", 0, core.LOCAL_SOURCE, publish)
+
+	if *session.Options.UpdateBaseline {
+		if err := session.Baseline.Save(); err != nil {
+			session.Log.Error("Could not write baseline: %s", err)
+			return 1
+		}
+
+		count := len(session.Baseline.Findings)
+		session.Log.Important("Wrote %d %s to %s", count, core.Pluralize(count, "finding", "findings"), *session.Options.Baseline)
+		return 0
+	}
+
+	found := int(session.Stats.GetFindings())
+	baselined := int(session.Stats.GetBaselinedFindings())
+	session.Log.Important("%d new %s, %d suppressed by baseline", found, core.Pluralize(found, "finding", "findings"), baselined)
+
+	if found > 0 {
+		return 1
+	}
+
+	return 0
+}
+
+// serveMetrics serves the Prometheus metrics.
+func serveMetrics(listener net.Listener) {
+	session.Log.Info("Serving metrics on http://%s/metrics", listener.Addr())
+	if err := session.ServeMetrics(listener); err != nil {
+		session.Log.Error("Metrics server stopped: %s", err)
+	}
+}
+
+// serveLive serves the live dashboard of published findings.
+func serveLive(listener net.Listener) {
+	session.Log.Info("Live dashboard on http://%s/", listener.Addr())
+	if err := session.Live.Serve(listener); err != nil {
+		session.Log.Error("Live dashboard stopped: %s", err)
+	}
+}
+
+// serveCoordinator hands queued work to workers instead of processing it in
+// this process.
+func serveCoordinator(listener net.Listener) {
+	session.Log.Info("Coordinating workers on %s", listener.Addr())
+	if err := core.NewCoordinator(session, publish).Serve(listener); err != nil {
+		session.Log.Error("Coordinator stopped: %s", err)
+	}
+}
+
+// serve runs scans submitted over the REST API until the session stops.
+func serve() int {
+	listener, err := net.Listen("tcp", *session.Options.Serve)
+	if err != nil {
+		session.Log.Error("Could not start the API server: %s", err)
+		return 1
+	}
+
+	// the API clones arbitrary repositories and returns the secrets it finds
+	if session.Config.ApiToken == "" && !core.IsLoopback(listener.Addr()) {
+		session.Log.Error("Refusing to serve the scan API on %s without an api_token. Set one, or listen on a loopback address such as 127.0.0.1:8080", listener.Addr())
+		listener.Close()
+		return 1
+	}
+
+	session.HandleSignals()
+	session.Log.Important("Serving the scan API on %s", listener.Addr())
+	if err := core.NewScanServer(session).Serve(listener); err != nil {
+		session.Log.Error("API server stopped: %s", err)
+		session.Shutdown()
+		return 1
+	}
+
+	session.Shutdown()
+	return 0
+}
+
 func main() {
+	options, _ := core.ParseOptions()
+	if flag.NArg() > 0 {
+		os.Exit(runCommand(options, flag.Arg(0)))
+	}
+
+	session = core.GetSession()
+	if len(*session.Options.Local) > 0 {
+		os.Exit(scanLocal())
+	}
+
+	if len(*session.Options.Metrics) > 0 {
+		listener, err := net.Listen("tcp", *session.Options.Metrics)
+		if err != nil {
+			session.Log.Fatal("Could not serve metrics: %s", err)
+		}
+
+		go serveMetrics(listener)
+	}
+
+	if len(*session.Options.Serve) > 0 {
+		os.Exit(serve())
+	}
+
+	if len(*session.Options.Worker) > 0 {
+		session.HandleSignals()
+		runWorker()
+		session.Shutdown()
+		return
+	}
+
+	var coordinator net.Listener
+	if len(*session.Options.Coordinator) > 0 {
+		var err error
+		if coordinator, err = net.Listen("tcp", *session.Options.Coordinator); err != nil {
+			session.Log.Fatal("Could not start the coordinator: %s", err)
+		}
+
+		// workers are handed GitHub URLs and report findings, secrets included
+		if session.Config.CoordinatorToken == "" && !core.IsLoopback(coordinator.Addr()) {
+			session.Log.Fatal("Refusing to coordinate workers on %s without a coordinator_token. Set one, or listen on a loopback address such as 127.0.0.1:8080", coordinator.Addr())
+		}
+	}
+
+	if len(*session.Options.Live) > 0 {
+		listener, err := net.Listen("tcp", *session.Options.Live)
+		if err != nil {
+			session.Log.Fatal("Could not start the live dashboard: %s", err)
+		}
+
+		// the dashboard shows every finding, with its location
+		if session.Config.LiveToken == "" && !core.IsLoopback(listener.Addr()) {
+			session.Log.Fatal("Refusing to serve the live dashboard on %s without a live_token. Set one, or listen on a loopback address such as 127.0.0.1:8081", listener.Addr())
+		}
+
+		session.Live = core.NewLiveDashboard(session)
+		go serveLive(listener)
+	}
+
 	ui := core.GetUI()
 	ui.Initialize()
 
 	go core.Search(session)
-	go ProcessSearches()
+	if coordinator != nil {
+		go serveCoordinator(coordinator)
+	} else {
+		go ProcessSearches()
+	}
 
 	// go core.GetRepositories(session)
 	// go ProcessRepositories()
@@ -250,5 +532,8 @@ func main() {
 	// 	go ProcessGists()
 	// }
 
+	session.HandleSignals()
 	ui.Run()
+
+	session.Shutdown()
 }
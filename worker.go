@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/eth0izzle/shhgit/core"
+)
+
+// reportTimeout bounds how long a worker waits on the coordinator to accept
+// a report, so a finished scan is still reported while shutting down.
+const reportTimeout = 10 * time.Second
+
+// errWorkerStopping is reported for a lease whose scan was cut short by the
+// worker shutting down, so the coordinator hands it out again.
+var errWorkerStopping = errors.New("worker stopping")
+
+// runWorker leases work from the --worker coordinator on every thread until
+// the session stops.
+func runWorker() {
+	client := core.NewCoordinatorClient(*session.Options.Worker, session.CurrentConfig().CoordinatorToken)
+	session.Log.Important("Worker %s taking work from %s", client.Worker(), *session.Options.Worker)
+
+	for i := 0; i < *session.Options.Threads; i++ {
+		session.Workers.Add(1)
+		go func() {
+			defer session.Workers.Done()
+			for session.Context.Err() == nil {
+				lease, err := client.Lease(session.Context)
+				if err != nil {
+					if session.Context.Err() == nil {
+						session.Log.Warn("Could not lease work: %s", err)
+						sleep(5 * time.Second)
+					}
+					continue
+				}
+
+				if lease != nil {
+					work(client, lease)
+				}
+			}
+		}()
+	}
+
+	<-session.Context.Done()
+}
+
+// work processes one lease, extending it while it runs, and reports the
+// findings or the failure back to the coordinator.
+func work(client *core.CoordinatorClient, lease *core.Lease) {
+	ctx, cancel := context.WithCancel(session.Context)
+	defer cancel()
+	go heartbeat(ctx, client, lease)
+
+	var (
+		lock     sync.Mutex
+		findings []*core.MatchEvent
+	)
+	report := func(event *core.MatchEvent) {
+		lock.Lock()
+		findings = append(findings, event)
+		lock.Unlock()
+	}
+
+	err := processLease(lease, report)
+	cancel()
+
+	// the findings of an interrupted scan are incomplete, and completing the
+	// lease would drop the item
+	if err == nil && session.Context.Err() != nil {
+		err = errWorkerStopping
+	}
+
+	reportCtx, cancelReport := context.WithTimeout(context.Background(), reportTimeout)
+	defer cancelReport()
+
+	if err != nil {
+		err = client.Fail(reportCtx, lease.Id, err)
+	} else {
+		err = client.Complete(reportCtx, lease.Id, findings)
+	}
+
+	if err != nil {
+		session.Log.Warn("Could not report %s item %s: %s", lease.Queue, lease.Id, err)
+	}
+}
+
+func processLease(lease *core.Lease, report func(*core.MatchEvent)) error {
+	switch lease.Queue {
+	case core.QueueRepositories:
+		var repository core.GitResource
+		if err := json.Unmarshal(lease.Item, &repository); err != nil {
+			return err
+		}
+		return processRepository(repository, report)
+	case core.QueueGists:
+		var gistUrl string
+		if err := json.Unmarshal(lease.Item, &gistUrl); err != nil {
+			return err
+		}
+		return processRepositoryOrGist(gistUrl, "", -1, core.GIST_SOURCE, report)
+	case core.QueueComments:
+		var commentBody string
+		if err := json.Unmarshal(lease.Item, &commentBody); err != nil {
+			return err
+		}
+		return processComment(commentBody, report)
+	case core.QueueSearchResults:
+		var queued core.QueuedSearchResult
+		if err := json.Unmarshal(lease.Item, &queued); err != nil {
+			return err
+		}
+
+		searchResult, ok := session.ResolveSearchResult(queued)
+		if !ok {
+			session.Log.Warn("Skipping search result for unknown signature %s", queued.Signature)
+			return nil
+		}
+		return processSearchResult(searchResult, report)
+	}
+
+	return fmt.Errorf("unknown queue %s", lease.Queue)
+}
+
+// heartbeat extends lease until ctx is done. The interval is taken from the
+// lease's expiry, which is in the coordinator's clock, so it has a floor.
+func heartbeat(ctx context.Context, client *core.CoordinatorClient, lease *core.Lease) {
+	interval := time.Until(lease.Expires) / 3
+	if interval < 10*time.Second {
+		interval = 10 * time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := client.Heartbeat(ctx, lease.Id); err != nil && ctx.Err() == nil {
+				session.Log.Warn("Could not extend lease on %s item %s: %s", lease.Queue, lease.Id, err)
+			}
+		}
+	}
+}
+
+func sleep(duration time.Duration) {
+	select {
+	case <-time.After(duration):
+	case <-session.Context.Done():
+	}
+}